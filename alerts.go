@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// alertKind describes one GitHub-style alert type.
+type alertKind struct {
+	Name  string
+	Title string
+	Icon  string // SVG path data (16x16 octicon)
+}
+
+var alertKinds = map[string]alertKind{
+	"note": {
+		Name:  "note",
+		Title: "Note",
+		Icon:  "M0 8a8 8 0 1 1 16 0A8 8 0 0 1 0 8Zm8-6.5a6.5 6.5 0 1 0 0 13 6.5 6.5 0 0 0 0-13ZM6.5 7.75A.75.75 0 0 1 7.25 7h1a.75.75 0 0 1 .75.75v2.75h.25a.75.75 0 0 1 0 1.5h-2a.75.75 0 0 1 0-1.5h.25v-2h-.25a.75.75 0 0 1-.75-.75ZM8 6a1 1 0 1 1 0-2 1 1 0 0 1 0 2Z",
+	},
+	"tip": {
+		Name:  "tip",
+		Title: "Tip",
+		Icon:  "M8 1.5c-2.363 0-4 1.69-4 3.75 0 .984.424 1.625.984 2.304l.214.253c.223.264.47.556.673.848.284.411.537.896.621 1.49a.75.75 0 0 1-1.484.211c-.04-.282-.163-.547-.37-.847a8.456 8.456 0 0 0-.542-.68c-.084-.1-.173-.205-.268-.32C3.201 7.75 2.5 6.766 2.5 5.25 2.5 2.31 4.863 0 8 0s5.5 2.31 5.5 5.25c0 1.516-.701 2.5-1.328 3.259-.095.115-.184.22-.268.319-.207.245-.383.453-.541.681-.208.3-.33.565-.37.847a.751.751 0 0 1-1.485-.212c.084-.593.337-1.078.621-1.489.203-.292.45-.584.673-.848.075-.088.147-.173.213-.253.561-.679.985-1.32.985-2.304 0-2.06-1.637-3.75-4-3.75ZM5.75 12h4.5a.75.75 0 0 1 0 1.5h-4.5a.75.75 0 0 1 0-1.5ZM6 15.25a.75.75 0 0 1 .75-.75h2.5a.75.75 0 0 1 0 1.5h-2.5a.75.75 0 0 1-.75-.75Z",
+	},
+	"important": {
+		Name:  "important",
+		Title: "Important",
+		Icon:  "M0 1.75C0 .784.784 0 1.75 0h12.5C15.216 0 16 .784 16 1.75v9.5A1.75 1.75 0 0 1 14.25 13H8.06l-2.573 2.573A1.458 1.458 0 0 1 3 14.543V13H1.75A1.75 1.75 0 0 1 0 11.25Zm1.75-.25a.25.25 0 0 0-.25.25v9.5c0 .138.112.25.25.25h2a.75.75 0 0 1 .75.75v2.19l2.72-2.72a.749.749 0 0 1 .53-.22h6.5a.25.25 0 0 0 .25-.25v-9.5a.25.25 0 0 0-.25-.25Zm7 2.25v2.5a.75.75 0 0 1-1.5 0v-2.5a.75.75 0 0 1 1.5 0ZM9 9a1 1 0 1 1-2 0 1 1 0 0 1 2 0Z",
+	},
+	"warning": {
+		Name:  "warning",
+		Title: "Warning",
+		Icon:  "M6.457 1.047c.659-1.234 2.427-1.234 3.086 0l6.082 11.378A1.75 1.75 0 0 1 14.082 15H1.918a1.75 1.75 0 0 1-1.543-2.575Zm1.763.707a.25.25 0 0 0-.44 0L1.698 13.132a.25.25 0 0 0 .22.368h12.164a.25.25 0 0 0 .22-.368Zm.53 3.996v2.5a.75.75 0 0 1-1.5 0v-2.5a.75.75 0 0 1 1.5 0ZM9 11a1 1 0 1 1-2 0 1 1 0 0 1 2 0Z",
+	},
+	"caution": {
+		Name:  "caution",
+		Title: "Caution",
+		Icon:  "M4.47.22A.749.749 0 0 1 5 0h6c.199 0 .389.079.53.22l4.25 4.25c.141.14.22.331.22.53v6a.749.749 0 0 1-.22.53l-4.25 4.25A.749.749 0 0 1 11 16H5a.749.749 0 0 1-.53-.22L.22 11.53A.749.749 0 0 1 0 11V5c0-.199.079-.389.22-.53Zm.84 1.28L1.5 5.31v5.38l3.81 3.81h5.38l3.81-3.81V5.31L10.69 1.5ZM8 4a.75.75 0 0 1 .75.75v3.5a.75.75 0 0 1-1.5 0v-3.5A.75.75 0 0 1 8 4Zm0 8a1 1 0 1 1 0-2 1 1 0 0 1 0 2Z",
+	},
+}
+
+// alertAliases maps the names used by other tools' admonitions onto the
+// five GitHub alert types.
+var alertAliases = map[string]string{
+	"info":    "note",
+	"hint":    "tip",
+	"success": "tip",
+	"danger":  "caution",
+	"error":   "caution",
+}
+
+func lookupAlertKind(name string) (alertKind, bool) {
+	name = strings.ToLower(name)
+	if alias, ok := alertAliases[name]; ok {
+		name = alias
+	}
+	kind, ok := alertKinds[name]
+	return kind, ok
+}
+
+// alert is the parsed form of a "> [!TYPE]" blockquote.
+type alert struct {
+	Kind alertKind
+	// Collapse is 0 for a plain callout, '+' for a collapsible callout that
+	// starts open and '-' for one that starts closed.
+	Collapse byte
+}
+
+var (
+	alertMarkerPattern = regexp.MustCompile(`^\[!([A-Za-z]+)\]([+-]?)[ \t]*`)
+	alertFencePattern  = regexp.MustCompile(`^:::[ \t]*([A-Za-z]+)([+-]?)[ \t]*(.*)$`)
+)
+
+// expandAlertFences rewrites ":::warning Title" ... ":::" fences into the
+// equivalent "> [!WARNING] Title" blockquote so both forms share one
+// rendering path. Every line keeps its position in the document.
+func expandAlertFences(markdown []byte) []byte {
+	if !bytes.Contains(markdown, []byte(":::")) {
+		return markdown
+	}
+
+	var out bytes.Buffer
+	var fence codeFence
+	inAlert := false
+	scanner := bufio.NewScanner(bytes.NewReader(markdown))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(markdown)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if fence.open == "" {
+			if m := alertFencePattern.FindStringSubmatch(trimmed); m != nil && !inAlert {
+				if _, ok := lookupAlertKind(m[1]); ok {
+					inAlert = true
+					line = strings.TrimSpace(fmt.Sprintf("> [!%s]%s %s", strings.ToUpper(m[1]), m[2], m[3]))
+					out.WriteString(line + "\n")
+					continue
+				}
+			}
+			if inAlert && trimmed == ":::" {
+				inAlert = false
+				out.WriteString("\n")
+				continue
+			}
+		}
+
+		fence.inside(line)
+
+		if inAlert {
+			line = strings.TrimRight("> "+line, " ")
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.Bytes()
+}
+
+// transformAlerts finds blockquotes that start with an alert marker and
+// records them for RenderNode. Blackfriday merges blockquotes separated only
+// by blank lines, so a marker paragraph in the middle of a blockquote starts
+// a new one.
+func (r *CustomHTMLRenderer) transformAlerts(doc *blackfriday.Node) {
+	var quotes []*blackfriday.Node
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && node.Type == blackfriday.BlockQuote {
+			quotes = append(quotes, node)
+		}
+		return blackfriday.GoToNext
+	})
+
+	for _, quote := range quotes {
+		splitAlertBlockQuote(quote)
+	}
+
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && node.Type == blackfriday.BlockQuote {
+			r.markAlert(node)
+		}
+		return blackfriday.GoToNext
+	})
+}
+
+func splitAlertBlockQuote(quote *blackfriday.Node) {
+	for child := quote.FirstChild; child != nil; child = child.Next {
+		if child == quote.FirstChild || !startsWithAlertMarker(child) {
+			continue
+		}
+		rest := blackfriday.NewNode(blackfriday.BlockQuote)
+		for child != nil {
+			next := child.Next
+			child.Unlink()
+			rest.AppendChild(child)
+			child = next
+		}
+		insertAfter(quote, rest)
+		splitAlertBlockQuote(rest)
+		return
+	}
+}
+
+func insertAfter(node, sibling *blackfriday.Node) {
+	if node.Next != nil {
+		node.Next.InsertBefore(sibling)
+	} else {
+		node.Parent.AppendChild(sibling)
+	}
+}
+
+func startsWithAlertMarker(para *blackfriday.Node) bool {
+	if para.Type != blackfriday.Paragraph || para.FirstChild == nil || para.FirstChild.Type != blackfriday.Text {
+		return false
+	}
+	m := alertMarkerPattern.FindSubmatch(para.FirstChild.Literal)
+	if m == nil {
+		return false
+	}
+	_, ok := lookupAlertKind(string(m[1]))
+	return ok
+}
+
+// markAlert turns the first line of the marker paragraph into a title
+// paragraph and keeps the remaining lines as the first body paragraph.
+func (r *CustomHTMLRenderer) markAlert(quote *blackfriday.Node) {
+	para := quote.FirstChild
+	if para == nil || !startsWithAlertMarker(para) {
+		return
+	}
+	text := para.FirstChild
+	m := alertMarkerPattern.FindSubmatch(text.Literal)
+	kind, _ := lookupAlertKind(string(m[1]))
+	a := alert{Kind: kind}
+	if len(m[2]) > 0 {
+		a.Collapse = m[2][0]
+	}
+	text.Literal = text.Literal[len(m[0]):]
+
+	title := blackfriday.NewNode(blackfriday.Paragraph)
+	for child := para.FirstChild; child != nil; {
+		next := child.Next
+		if child.Type == blackfriday.Text {
+			if i := bytes.IndexByte(child.Literal, '\n'); i >= 0 {
+				head := blackfriday.NewNode(blackfriday.Text)
+				head.Literal = bytes.TrimRight(child.Literal[:i], " \t")
+				child.Literal = child.Literal[i+1:]
+				if len(head.Literal) > 0 {
+					title.AppendChild(head)
+				}
+				if len(child.Literal) == 0 {
+					child.Unlink()
+				}
+				break
+			}
+		}
+		child.Unlink()
+		if child.Type != blackfriday.Text || len(bytes.TrimSpace(child.Literal)) > 0 {
+			title.AppendChild(child)
+		}
+		child = next
+	}
+	para.InsertBefore(title)
+	if para.FirstChild == nil {
+		para.Unlink()
+	}
+
+	r.alerts[quote] = a
+	r.alertTitles[title] = a
+}
+
+func (r *CustomHTMLRenderer) renderAlert(w io.Writer, a alert, entering bool) {
+	if a.Collapse == 0 {
+		if entering {
+			fmt.Fprintf(w, "\n<div class=\"markdown-alert markdown-alert-%s\">\n", a.Kind.Name)
+		} else {
+			w.Write([]byte("</div>\n"))
+		}
+		return
+	}
+	if entering {
+		open := ""
+		if a.Collapse == '+' {
+			open = " open"
+		}
+		fmt.Fprintf(w, "\n<details class=\"markdown-alert markdown-alert-%s\"%s>\n", a.Kind.Name, open)
+	} else {
+		w.Write([]byte("</details>\n"))
+	}
+}
+
+func (r *CustomHTMLRenderer) renderAlertTitle(w io.Writer, node *blackfriday.Node, a alert, entering bool) {
+	tag := "p"
+	if a.Collapse != 0 {
+		tag = "summary"
+	}
+	if !entering {
+		fmt.Fprintf(w, "</%s>\n", tag)
+		return
+	}
+	fmt.Fprintf(w, `<%s class="markdown-alert-title"><svg class="octicon" viewBox="0 0 16 16" width="16" height="16" aria-hidden="true"><path d="%s"></path></svg>`, tag, a.Kind.Icon)
+	if node.FirstChild == nil {
+		io.WriteString(w, a.Kind.Title)
+	}
+}
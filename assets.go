@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// htmlURLPattern finds the src of <img> and the href of <a> tags written as
+// raw HTML.
+var htmlURLPattern = regexp.MustCompile(`(?i)(<(img|a)\b[^>]*?\b(?:src|href)\s*=\s*)("[^"]*"|'[^']*')`)
+
+// rewriteLocalURLs inlines or copies the local files that doc references,
+// according to --embed-images and --copy-assets, and points the Image and
+// Link nodes (and raw HTML <img> and <a> tags) at the result.
+func rewriteLocalURLs(doc *blackfriday.Node) {
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering {
+			return blackfriday.GoToNext
+		}
+		switch node.Type {
+		case blackfriday.Image:
+			node.LinkData.Destination = []byte(localImageURL(string(node.LinkData.Destination)))
+		case blackfriday.Link:
+			node.LinkData.Destination = []byte(localLinkURL(string(node.LinkData.Destination)))
+		case blackfriday.HTMLSpan, blackfriday.HTMLBlock:
+			node.Literal = htmlURLPattern.ReplaceAllFunc(node.Literal, func(tag []byte) []byte {
+				m := htmlURLPattern.FindSubmatch(tag)
+				quote, value := m[3][0], string(m[3][1:len(m[3])-1])
+				if strings.EqualFold(string(m[2]), "img") {
+					value = localImageURL(value)
+				} else {
+					value = localLinkURL(value)
+				}
+				return []byte(fmt.Sprintf("%s%c%s%c", m[1], quote, value, quote))
+			})
+		}
+		return blackfriday.GoToNext
+	})
+}
+
+func localImageURL(src string) string {
+	if options.EmbedImages {
+		return embedImage(src)
+	}
+	if options.CopyAssets {
+		return copyLocalURL(src, true)
+	}
+	return src
+}
+
+// localLinkURL copies linked files other than Markdown documents, which
+// are converted rather than copied.
+func localLinkURL(href string) string {
+	if isMarkdownLink(href) {
+		if options.MarkdownLinks {
+			return markdownLinkToHTML(href)
+		}
+		return href
+	}
+	if !options.CopyAssets {
+		return href
+	}
+	return copyLocalURL(href, false)
+}
+
+// markdownLinkToHTML points a relative link to a Markdown file at the page
+// it is converted to, keeping any fragment.
+func markdownLinkToHTML(href string) string {
+	u, err := url.Parse(href)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return href
+	}
+	if options.Pages == nil {
+		u.Path = strings.TrimSuffix(u.Path, path.Ext(u.Path)) + ".html"
+		return u.String()
+	}
+
+	file, err := filepath.Abs(filepath.Join(options.BaseDir, filepath.FromSlash(u.Path)))
+	if err != nil {
+		return href
+	}
+	page, ok := options.Pages[file]
+	if !ok {
+		return href
+	}
+	dir, err := filepath.Abs(options.OutputDir)
+	if err != nil {
+		return href
+	}
+	rel, err := filepath.Rel(dir, page)
+	if err != nil {
+		return href
+	}
+	u.Path = filepath.ToSlash(rel)
+	return u.String()
+}
+
+func isMarkdownLink(href string) bool {
+	u, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// localPath returns the file a URL refers to, relative to the input file's
+// directory, or false for remote, data, root-relative and fragment-only URLs.
+func localPath(ref string) (string, bool) {
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return "", false
+	}
+	return filepath.Join(options.BaseDir, filepath.FromSlash(u.Path)), true
+}
+
+// allowedLocalFile reports whether file may be read into the output. With
+// --safe, only files below the document's directory, or the workspace or
+// site source, are; ref is the URL that named it, for the warning.
+func allowedLocalFile(file, ref, action string) bool {
+	if !options.Safe {
+		return true
+	}
+	root := includeRoot(options.BaseDir)
+	if abs, err := filepath.Abs(file); err == nil && withinRoot(root, abs) {
+		return true
+	}
+	warnOnce(fmt.Sprintf("%s not %s: files outside %s cannot be used with --safe", ref, action, filepath.Base(root)))
+	return false
+}
+
+// copyLocalURL copies the file ref points to into the assets folder and
+// returns the new URL, keeping any query and fragment. Missing files are
+// only reported for images; a link to a file that does not exist may still
+// be intended, e.g. a directory or a page served elsewhere.
+func copyLocalURL(ref string, image bool) string {
+	file, ok := localPath(ref)
+	if !ok || options.OutputDir == "" || !allowedLocalFile(file, ref, "copied") {
+		return ref
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		if image {
+			warnOnce(fmt.Sprintf("image %s not copied: file not found", ref))
+		}
+		return ref
+	}
+
+	copied, err := assets.copy(file)
+	if err != nil {
+		warnOnce(fmt.Sprintf("%s not copied: %v", ref, err))
+		return ref
+	}
+
+	rel, err := filepath.Rel(options.OutputDir, copied)
+	if err != nil {
+		return ref
+	}
+	u, _ := url.Parse(ref)
+	u.Path = filepath.ToSlash(rel)
+	return u.String()
+}
+
+// assetCopier copies files into the assets folder. It is shared by every
+// document converted in one run, so a file referenced by several pages is
+// copied once; with --hash-assets, identical content is copied once even
+// when it comes from different files.
+type assetCopier struct {
+	mu     sync.Mutex
+	copied map[string]string // source file or content hash -> copy
+	names  map[string]string // copy -> source file or content hash
+}
+
+var assets = &assetCopier{copied: map[string]string{}, names: map[string]string{}}
+
+// files returns the paths of every copy made so far.
+func (a *assetCopier) files() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	files := make([]string, 0, len(a.names))
+	for file := range a.names {
+		files = append(files, file)
+	}
+	return files
+}
+
+// copy copies file into the assets folder of the current output directory
+// and returns the path of the copy. Without --hash-assets, files with the
+// same name from different directories get a numeric suffix.
+func (a *assetCopier) copy(file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return "", err
+	}
+	dir := options.AssetsDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(options.OutputDir, dir)
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ext := filepath.Ext(abs)
+	stem := strings.TrimSuffix(filepath.Base(abs), ext)
+	key, name := abs, stem+ext
+	if options.HashAssets {
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		key, name = hash, stem+"."+hash[:10]+ext
+	}
+	if copied, ok := a.copied[dir+"\x00"+key]; ok {
+		return copied, nil
+	}
+
+	dest := filepath.Join(dir, name)
+	for i := 2; a.names[dest] != "" && a.names[dest] != key; i++ {
+		dest = filepath.Join(dir, fmt.Sprintf("%s-%d%s", stem, i, ext))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(dest, data, 0644); err != nil {
+		return "", err
+	}
+	a.copied[dir+"\x00"+key] = dest
+	a.names[dest] = key
+	return dest, nil
+}
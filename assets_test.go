@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// assetFixture makes a document directory holding pic.png next to
+// secret.txt and secret.png outside it, and points the options at it.
+func assetFixture(t *testing.T) (docDir, outDir string) {
+	t.Helper()
+	root := t.TempDir()
+	docDir = filepath.Join(root, "docs")
+	outDir = filepath.Join(root, "out")
+	for file, content := range map[string]string{
+		filepath.Join(docDir, "pic.png"):  "\x89PNG\r\n\x1a\n",
+		filepath.Join(root, "secret.txt"): "secret",
+		filepath.Join(root, "secret.png"): "secret",
+	} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	options.BaseDir = docDir
+	options.OutputDir = outDir
+	options.AssetsDir = "assets"
+	return docDir, outDir
+}
+
+func TestSafeCopyAssetsStaysInDocumentDir(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	_, outDir := assetFixture(t)
+	options.CopyAssets = true
+	options.Safe = true
+
+	html := convertMarkdownToHTMLBody([]byte("[s](../secret.txt) ![p](pic.png) ![s](../../../../../../../secret.txt)\n"))
+	if !strings.Contains(html, `href="../secret.txt"`) {
+		t.Errorf("link outside the document directory rewritten:\n%s", html)
+	}
+	if !strings.Contains(html, `src="assets/pic.png"`) {
+		t.Errorf("image inside the document directory not copied:\n%s", html)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "assets", "secret.txt")); err == nil {
+		t.Error("secret.txt copied into the output")
+	}
+}
+
+func TestSafeEmbedImagesStaysInDocumentDir(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	assetFixture(t)
+	options.EmbedImages = true
+	options.Safe = true
+
+	html := convertMarkdownToHTMLBody([]byte("![s](../secret.png) ![p](pic.png)\n<img src=\"../secret.png\">\n"))
+	if strings.Contains(html, "c2VjcmV0") { // "secret" in base64
+		t.Errorf("file outside the document directory embedded:\n%s", html)
+	}
+	if !strings.Contains(html, `src="data:image/png;base64,`) {
+		t.Errorf("image inside the document directory not embedded:\n%s", html)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// docLink is a link from a document to a local Markdown file.
+type docLink struct {
+	File    string // absolute path of the linked file
+	Context string // text of the paragraph, list item or cell holding the link
+}
+
+// linkedDocument is a document together with the files it links to.
+type linkedDocument struct {
+	File  string // absolute path
+	Title string
+	Links []docLink
+}
+
+// backlink is a document linking to the one being rendered.
+type backlink struct {
+	File    string
+	Title   string
+	Context string
+}
+
+const maxBacklinkContext = 200
+
+// collectDocumentLinks returns the links of doc to local Markdown files,
+// both written as ordinary links and as [[wiki links]], with relative
+// paths resolved against baseDir.
+func collectDocumentLinks(doc *blackfriday.Node, baseDir string, math []mathSpan) []docLink {
+	var links []docLink
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering || node.Type != blackfriday.Link {
+			return blackfriday.GoToNext
+		}
+		file, ok := linkedFile(string(node.LinkData.Destination), baseDir)
+		if !ok {
+			return blackfriday.GoToNext
+		}
+		if abs, err := filepath.Abs(file); err == nil {
+			links = append(links, docLink{File: abs, Context: linkContext(node, math)})
+		}
+		return blackfriday.GoToNext
+	})
+	return links
+}
+
+// linkedFile returns the Markdown file a link destination points to.
+func linkedFile(dest, baseDir string) (string, bool) {
+	if target, ok := wikiTarget(dest); ok {
+		file, _, ok := resolveWikiTarget(target, baseDir)
+		return file, ok && file != ""
+	}
+	if !isMarkdownLink(dest) {
+		return "", false
+	}
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(u.Path, "/") {
+		return "", false
+	}
+	return filepath.Join(baseDir, filepath.FromSlash(u.Path)), true
+}
+
+// linkContext returns the plain text of the block holding a link, cut to a
+// readable length.
+func linkContext(link *blackfriday.Node, math []mathSpan) string {
+	block := link.Parent
+	for block != nil && block.Type != blackfriday.Paragraph && block.Type != blackfriday.Heading &&
+		block.Type != blackfriday.TableCell && block.Type != blackfriday.Item {
+		block = block.Parent
+	}
+	if block == nil {
+		return ""
+	}
+
+	var text strings.Builder
+	block.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		switch node.Type {
+		case blackfriday.Text, blackfriday.Code:
+			text.WriteString(restoreMathSource(string(node.Literal), math))
+		case blackfriday.Softbreak, blackfriday.Hardbreak:
+			text.WriteString(" ")
+		}
+		return blackfriday.GoToNext
+	})
+
+	context := strings.Join(strings.Fields(text.String()), " ")
+	if runes := []rune(context); len(runes) > maxBacklinkContext {
+		context = strings.TrimSpace(string(runes[:maxBacklinkContext])) + " …"
+	}
+	return context
+}
+
+// scanDocument reads a Markdown file and collects its links without
+// rendering it, for the backlinks of documents that are not being
+// converted.
+func scanDocument(file string) (linkedDocument, error) {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return linkedDocument{}, err
+	}
+	meta, markdown := splitFrontMatter(source)
+	title := meta.Title
+	if title == "" {
+		title = firstHeading(markdown)
+	}
+	if title == "" {
+		title = filepath.Base(file)
+	}
+	if options.Extensions&ExtWikiLinks != 0 {
+		markdown = expandWikiLinks(markdown)
+	}
+	doc := blackfriday.New(blackfriday.WithExtensions(blackfriday.CommonExtensions)).Parse(markdown)
+	return linkedDocument{File: file, Title: title, Links: collectDocumentLinks(doc, filepath.Dir(file), nil)}, nil
+}
+
+// findBacklinks returns, for every linked file, the other documents that
+// link to it, ordered by title. A document linking to a file more than once
+// is listed once, with the context of its first link.
+func findBacklinks(docs []linkedDocument) map[string][]backlink {
+	found := map[string][]backlink{}
+	for _, doc := range docs {
+		seen := map[string]bool{doc.File: true}
+		for _, link := range doc.Links {
+			if seen[link.File] {
+				continue
+			}
+			seen[link.File] = true
+			found[link.File] = append(found[link.File], backlink{File: doc.File, Title: doc.Title, Context: link.Context})
+		}
+	}
+	for _, refs := range found {
+		sort.SliceStable(refs, func(i, j int) bool {
+			return strings.ToLower(refs[i].Title) < strings.ToLower(refs[j].Title)
+		})
+	}
+	return found
+}
+
+// renderBacklinks renders the "Linked references" section of a page, with
+// href giving the URL of each linking document.
+func renderBacklinks(refs []backlink, href func(backlink) string) string {
+	if len(refs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("<section class=\"backlinks\">\n<h2>Linked references</h2>\n<ul>\n")
+	for _, ref := range refs {
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a>", template.HTMLEscapeString(href(ref)), template.HTMLEscapeString(ref.Title))
+		if ref.Context != "" {
+			fmt.Fprintf(&b, "<p>%s</p>", template.HTMLEscapeString(ref.Context))
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ul>\n</section>\n")
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var hrefPattern = regexp.MustCompile(`<a href="([^"]*)"`)
+
+func TestBatchLinksBetweenPages(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+
+	dir := t.TempDir()
+	for file, content := range map[string]string{
+		"a.md":            "# A\n\nSee [[Notes#Details]] and [guide](sub/guide.md#top), not [other](other.md).\n",
+		"sub/Notes.md":    "# Notes\n\n## Details\n",
+		"sub/guide.md":    "# Top\n\nBack to [[a]].\n",
+		"other.md":        "# Other\n",
+		"sub/unlisted.md": "# Unlisted\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(dir, "out")
+	if err := os.MkdirAll(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	inputs := []string{filepath.Join(dir, "a.md"), filepath.Join(dir, "sub", "Notes.md"), filepath.Join(dir, "sub", "guide.md")}
+	if err := convertBatch(inputs, out); err != nil {
+		t.Fatal(err)
+	}
+
+	links := func(page string) []string {
+		html, err := os.ReadFile(filepath.Join(out, page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hrefs []string
+		for _, m := range hrefPattern.FindAllStringSubmatch(string(html), -1) {
+			hrefs = append(hrefs, m[1])
+		}
+		return hrefs
+	}
+	follow := func(href string) {
+		u, err := url.Parse(href)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(out, filepath.FromSlash(u.Path))); err != nil {
+			t.Errorf("link %s leads nowhere: %v", href, err)
+		}
+	}
+
+	want := []string{"Notes.html#details", "guide.html#top", "other.md"}
+	got := links("a.html")
+	if len(got) < len(want) {
+		t.Fatalf("links of a.html = %v, want %v", got, want)
+	}
+	for i, href := range want {
+		if got[i] != href {
+			t.Errorf("link %d of a.html = %q, want %q", i, got[i], href)
+		}
+	}
+	follow(got[0])
+	follow(got[1])
+
+	if got := links("guide.html"); len(got) == 0 || got[0] != "a.html" {
+		t.Errorf("links of guide.html = %v, want a.html first", got)
+	} else {
+		follow(got[0])
+	}
+}
+
+func TestInputFlagJoinsBatch(t *testing.T) {
+	for _, tc := range []struct {
+		named string
+		args  []string
+		want  []string
+	}{
+		{"", []string{"a.md"}, []string{"a.md"}},
+		{"a.md", nil, []string{"a.md"}},
+		{"a.md", []string{"b.md"}, []string{"a.md", "b.md"}},
+		{"a.md", []string{"a.md", "b.md"}, []string{"a.md", "b.md"}},
+	} {
+		if got := inputList(tc.named, tc.args); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("inputList(%q, %q) = %q, want %q", tc.named, tc.args, got, tc.want)
+		}
+	}
+}
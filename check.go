@@ -0,0 +1,560 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+var (
+	// inlineLinkPattern matches [text](dest "title") and ![alt](dest) on one
+	// line. The text may hold brackets two levels deep, such as an image
+	// inside a link, and the destination one level of parentheses.
+	inlineLinkPattern = regexp.MustCompile(`(!?)\[((?:[^\[\]]|\[(?:[^\[\]]|\[[^\[\]]*\])*\])*)\]\(\s*(<[^>]*>|[^\s()]*(?:\([^\s()]*\)[^\s()]*)*)(?:\s+(?:"[^"]*"|'[^']*'|\([^)]*\)))?\s*\)`)
+	// referenceLinkPattern matches [text][label] and [text][].
+	referenceLinkPattern = regexp.MustCompile(`(!?)\[((?:[^\[\]]|\[[^\[\]]*\])*)\]\[([^\[\]]*)\]`)
+	// referenceDefinitionPattern matches [label]: dest.
+	referenceDefinitionPattern = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:[ \t]*(<[^>]*>|\S+)`)
+	// externalURLPattern matches <https://...> autolinks and bare URLs.
+	externalURLPattern = regexp.MustCompile(`https?://[^\s<>\[\]()"']+`)
+	// htmlIDPattern finds id and name attributes in raw HTML, which can be
+	// linked to like headings.
+	htmlIDPattern = regexp.MustCompile(`(?i)\b(?:id|name)\s*=\s*["']([^"']+)["']`)
+)
+
+// runCheck implements "mdreader check": it verifies the local links,
+// images, anchors and references of every Markdown file under the given
+// paths and exits with 1 if any is broken, or 2 on usage errors.
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	var format string
+	var external bool
+	var timeout time.Duration
+	var render renderFlags
+	fs.StringVar(&format, "format", "text", "Output format: text or json")
+	fs.BoolVar(&external, "external", false, "Also request external http(s) links and report those that fail")
+	fs.DurationVar(&timeout, "external-timeout", 10*time.Second, "Maximum time to wait for each external link with --external")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: mdreader check <docs/ | file.md>... [options]")
+		fs.PrintDefaults()
+	}
+	render.register(fs)
+
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !validFormat(format) {
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q, use text or json\n", format)
+		os.Exit(2)
+	}
+	render.apply(fs, positional[0])
+
+	c := newLinkChecker()
+	files := 0
+	for _, arg := range positional {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		// Wiki links resolve across a directory being checked, as they do
+		// in a site built from it.
+		root := arg
+		inputs := []string{arg}
+		options.WikiRoot = arg
+		if !info.IsDir() {
+			root = filepath.Dir(arg)
+			options.WikiRoot = ""
+		} else {
+			inputs = markdownFiles(arg)
+		}
+		for _, file := range inputs {
+			if err := c.checkFile(file, root); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(2)
+			}
+			files++
+		}
+	}
+	if external {
+		c.fetchExternal(timeout)
+	} else {
+		c.listExternal()
+	}
+
+	sortDiagnostics(c.diagnostics)
+	if err := writeDiagnostics(os.Stdout, format, c.diagnostics); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	problems := countSeverity(c.diagnostics, "error")
+	if format == "text" {
+		fmt.Fprintf(os.Stderr, "Checked %d files: %d problems, %d external links\n", files, problems, len(c.external))
+	}
+	if problems > 0 {
+		os.Exit(1)
+	}
+}
+
+// externalLink is an http(s) URL found in a document.
+type externalLink struct {
+	file         string
+	line, column int
+	url          string
+}
+
+// linkChecker collects the diagnostics of the files it checks and caches
+// the anchors of the files they link to.
+type linkChecker struct {
+	diagnostics []diagnostic
+	external    []externalLink
+	anchors     map[string]map[string]bool
+}
+
+func newLinkChecker() *linkChecker {
+	return &linkChecker{anchors: map[string]map[string]bool{}}
+}
+
+func (c *linkChecker) report(file string, line, column int, rule, format string, args ...interface{}) {
+	c.diagnostics = append(c.diagnostics, diagnostic{
+		File: file, Line: line, Column: column, Severity: "error", Rule: rule, Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// checkFile checks the links of one file. Links starting with "/" are
+// resolved against root.
+func (c *linkChecker) checkFile(file, root string) error {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	c.checkSource(file, root, source)
+	return nil
+}
+
+// checkSource checks the links of file as given by source, which may not
+// have been saved yet.
+func (c *linkChecker) checkSource(file, root string, source []byte) {
+	_, markdown := splitFrontMatter(source)
+	lines := maskCode(markdown)
+
+	definitions := map[string]bool{}
+	for i, line := range lines {
+		m := referenceDefinitionPattern.FindStringSubmatchIndex(line)
+		if m == nil {
+			continue
+		}
+		if label := line[m[2]:m[3]]; !strings.HasPrefix(label, "^") {
+			definitions[referenceLabel(label)] = true
+			c.checkDestination(file, root, i+1, utf8.RuneCountInString(line[:m[4]])+1, line[m[4]:m[5]], false)
+		}
+		lines[i] = line[:m[0]] + strings.Repeat(" ", m[1]-m[0]) + line[m[1]:]
+	}
+
+	c.checkText(file, root, strings.Join(lines, "\n"), definitions)
+}
+
+// paragraphBreakPattern matches a blank line, which no link can span.
+var paragraphBreakPattern = regexp.MustCompile(`\n[ \t>]*\n`)
+
+// checkText checks the links of a document with code masked out. Link
+// text may wrap across lines. Each kind of link is blanked out once
+// checked, so later patterns do not see it again.
+func (c *linkChecker) checkText(file, root, text string, definitions map[string]bool) {
+	var starts []int // offset of each line
+	for i := 0; i >= 0; {
+		starts = append(starts, i)
+		if next := strings.IndexByte(text[i:], '\n'); next >= 0 {
+			i += next + 1
+		} else {
+			i = -1
+		}
+	}
+	position := func(offset int) (line, column int) {
+		line = sort.SearchInts(starts, offset+1)
+		return line, utf8.RuneCountInString(text[starts[line-1]:offset]) + 1
+	}
+	destination := func(offset int, dest string, image bool) {
+		line, column := position(offset)
+		c.checkDestination(file, root, line, column, dest, image)
+	}
+	work := []byte(text)
+	blank := func(start, end int) {
+		for i := start; i < end; i++ {
+			if work[i] != '\n' {
+				work[i] = ' '
+			}
+		}
+	}
+
+	if options.Extensions&ExtWikiLinks != 0 {
+		for _, m := range wikiLinkPattern.FindAllStringSubmatchIndex(string(work), -1) {
+			if m[0] > 0 && work[m[0]-1] == '!' {
+				continue
+			}
+			line, column := position(m[0])
+			c.checkWikiLink(file, line, column, strings.TrimSpace(text[m[2]:m[3]]))
+			blank(m[0], m[1])
+		}
+	}
+
+	var inline func(start, end int)
+	inline = func(start, end int) {
+		for _, m := range inlineLinkPattern.FindAllSubmatchIndex(work[start:end], -1) {
+			for i := range m {
+				if m[i] >= 0 {
+					m[i] += start
+				}
+			}
+			if paragraphBreakPattern.MatchString(text[m[0]:m[1]]) {
+				continue
+			}
+			inline(m[4], m[5]) // an image inside a link's text
+			destination(m[6], text[m[6]:m[7]], m[3] > m[2])
+			blank(m[0], m[1])
+		}
+	}
+	inline(0, len(work))
+
+	for _, m := range referenceLinkPattern.FindAllSubmatchIndex(work, -1) {
+		if paragraphBreakPattern.MatchString(text[m[0]:m[1]]) {
+			continue
+		}
+		label := text[m[6]:m[7]]
+		if label == "" {
+			label = text[m[4]:m[5]]
+		}
+		if !strings.HasPrefix(label, "^") && !definitions[referenceLabel(label)] {
+			line, column := position(m[0])
+			c.report(file, line, column, "undefined-reference", "reference [%s] is not defined", label)
+		}
+		blank(m[0], m[1])
+	}
+
+	for _, m := range htmlURLPattern.FindAllSubmatchIndex(work, -1) {
+		destination(m[6]+1, text[m[6]+1:m[7]-1], strings.EqualFold(text[m[4]:m[5]], "img"))
+		blank(m[0], m[1])
+	}
+
+	for _, m := range externalURLPattern.FindAllIndex(work, -1) {
+		line, column := position(m[0])
+		u := strings.TrimRight(text[m[0]:m[1]], ".,;:!?")
+		c.external = append(c.external, externalLink{file: file, line: line, column: column, url: u})
+	}
+}
+
+// checkDestination checks a link or image URL: local files must exist, and
+// a #fragment pointing into a Markdown file must match one of its headings.
+func (c *linkChecker) checkDestination(file, root string, line, column int, dest string, image bool) {
+	if strings.HasPrefix(dest, "<") && strings.HasSuffix(dest, ">") {
+		dest = dest[1 : len(dest)-1]
+	}
+	if dest == "" {
+		c.report(file, line, column, "empty-link", "link has no destination")
+		return
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		c.report(file, line, column, "invalid-url", "invalid URL %s", dest)
+		return
+	}
+	switch {
+	case u.Scheme == "http" || u.Scheme == "https" || (u.Scheme == "" && u.Host != ""):
+		c.external = append(c.external, externalLink{file: file, line: line, column: column, url: dest})
+		return
+	case u.Scheme != "":
+		return // mailto:, data: and the like
+	}
+
+	target := file
+	if u.Path != "" {
+		if strings.HasPrefix(u.Path, "/") {
+			target = filepath.Join(root, filepath.FromSlash(u.Path))
+		} else {
+			target = filepath.Join(filepath.Dir(file), filepath.FromSlash(u.Path))
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			if image {
+				c.report(file, line, column, "missing-image", "image %s not found", u.Path)
+			} else {
+				c.report(file, line, column, "missing-file", "%s not found", u.Path)
+			}
+			return
+		}
+		if info.IsDir() {
+			return
+		}
+	}
+	if u.Fragment != "" && isMarkdownFile(target) && !c.anchorsOf(target)[u.Fragment] {
+		if u.Path == "" {
+			c.report(file, line, column, "missing-anchor", "no heading with ID #%s in this file", u.Fragment)
+		} else {
+			c.report(file, line, column, "missing-anchor", "no heading with ID #%s in %s", u.Fragment, u.Path)
+		}
+	}
+}
+
+// checkWikiLink checks that a [[wiki link]] and its #heading resolve.
+func (c *linkChecker) checkWikiLink(file string, line, column int, target string) {
+	name, heading, _ := strings.Cut(target, "#")
+	linked := file
+	if strings.TrimSpace(name) != "" {
+		var ok bool
+		if linked, _, ok = resolveWikiTarget(target, filepath.Dir(file)); !ok {
+			c.report(file, line, column, "broken-wiki-link", "no page named %q for [[%s]]", strings.TrimSpace(name), target)
+			return
+		}
+	}
+	if heading != "" {
+		if id := blackfriday.SanitizedAnchorName(heading); !c.anchorsOf(linked)[id] {
+			c.report(file, line, column, "missing-anchor", "no heading %q in [[%s]]", heading, target)
+		}
+	}
+}
+
+// anchorsOf returns the IDs a Markdown file's rendered page can be linked
+// to at: its heading IDs, made unique the way the renderer does, and ids
+// in raw HTML.
+func (c *linkChecker) anchorsOf(file string) map[string]bool {
+	file, _ = filepath.Abs(file)
+	if anchors, ok := c.anchors[file]; ok {
+		return anchors
+	}
+	anchors := map[string]bool{}
+	if source, err := os.ReadFile(file); err == nil {
+		anchors = documentAnchors(file, source)
+	}
+	c.anchors[file] = anchors
+	return anchors
+}
+
+// documentAnchors returns the anchors of file as given by source.
+func documentAnchors(file string, source []byte) map[string]bool {
+	anchors := map[string]bool{}
+	_, markdown := splitFrontMatter(source)
+	options.BaseDir = filepath.Dir(file)
+	options.Source = file
+	_, doc := parseSource(markdown)
+
+	used := map[string]int{}
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering {
+			return blackfriday.GoToNext
+		}
+		switch node.Type {
+		case blackfriday.Heading:
+			if node.HeadingID != "" && !node.IsTitleblock {
+				anchors[uniqueHeadingID(used, node.HeadingID)] = true
+			}
+		case blackfriday.HTMLBlock, blackfriday.HTMLSpan:
+			for _, m := range htmlIDPattern.FindAllSubmatch(node.Literal, -1) {
+				anchors[string(m[1])] = true
+			}
+		}
+		return blackfriday.GoToNext
+	})
+	return anchors
+}
+
+// uniqueHeadingID mirrors blackfriday's HTML renderer, which appends -1,
+// -2 and so on to repeated heading IDs.
+func uniqueHeadingID(used map[string]int, id string) string {
+	for count, found := used[id]; found; count, found = used[id] {
+		tmp := fmt.Sprintf("%s-%d", id, count+1)
+		if _, tmpFound := used[tmp]; !tmpFound {
+			used[id] = count + 1
+			id = tmp
+		} else {
+			id = id + "-1"
+		}
+	}
+	if _, found := used[id]; !found {
+		used[id] = 0
+	}
+	return id
+}
+
+// referenceLabel normalizes a reference label the way Markdown matches
+// them: case-insensitively, with runs of whitespace as one space.
+func referenceLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// maskCode returns the lines of markdown with code blocks emptied, and
+// inline code spans and HTML comments replaced by spaces, so positions stay
+// the same.
+func maskCode(markdown []byte) []string {
+	var lines []string
+	code := newCodeScanner()
+	comment := false
+	scanner := bufio.NewScanner(bytes.NewReader(markdown))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(markdown)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if code.inside(line) && !comment {
+			line = ""
+		} else {
+			line, comment = maskComments(maskCodeSpans(line), comment)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// maskComments replaces <!-- comments --> with spaces. comment is whether
+// the line starts inside a comment, and the result whether the next does.
+func maskComments(line string, comment bool) (string, bool) {
+	if !comment && !strings.Contains(line, "<!--") {
+		return line, false
+	}
+	masked := []byte(line)
+	for i := 0; i < len(line); {
+		if !comment {
+			start := strings.Index(line[i:], "<!--")
+			if start < 0 {
+				break
+			}
+			i += start
+			comment = true
+		}
+		end := strings.Index(line[i:], "-->")
+		stop := len(line)
+		if end >= 0 {
+			stop = i + end + len("-->")
+			comment = false
+		}
+		for j := i; j < stop; j++ {
+			masked[j] = ' '
+		}
+		i = stop
+	}
+	return string(masked), comment
+}
+
+// maskCodeSpans replaces the contents of `code spans` with spaces.
+func maskCodeSpans(line string) string {
+	if !strings.Contains(line, "`") {
+		return line
+	}
+	masked := []byte(line)
+	for i := 0; i < len(line); {
+		if line[i] != '`' {
+			i++
+			continue
+		}
+		n := 0
+		for i+n < len(line) && line[i+n] == '`' {
+			n++
+		}
+		end := strings.Index(line[i+n:], line[i:i+n])
+		if end < 0 {
+			i += n
+			continue
+		}
+		for j := i; j < i+n+end+n; j++ {
+			masked[j] = ' '
+		}
+		i += n + end + n
+	}
+	return string(masked)
+}
+
+// listExternal reports the external links without requesting them.
+func (c *linkChecker) listExternal() {
+	for _, link := range c.external {
+		c.diagnostics = append(c.diagnostics, diagnostic{
+			File: link.file, Line: link.line, Column: link.column, Severity: "info", Rule: "external-link",
+			Message: link.url + " not checked (use --external)",
+		})
+	}
+}
+
+// fetchExternal requests every external URL once, a few at a time, and
+// reports those that fail or return an error status.
+func (c *linkChecker) fetchExternal(timeout time.Duration) {
+	client := &http.Client{Timeout: timeout}
+	results := map[string]string{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, 8)
+	for _, link := range c.external {
+		if _, ok := results[link.url]; ok {
+			continue
+		}
+		results[link.url] = ""
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			problem := fetchURL(client, u)
+			mu.Lock()
+			results[u] = problem
+			mu.Unlock()
+		}(link.url)
+	}
+	wg.Wait()
+
+	for _, link := range c.external {
+		d := diagnostic{File: link.file, Line: link.line, Column: link.column, Severity: "info", Rule: "external-link", Message: link.url + " OK"}
+		if problem := results[link.url]; problem != "" {
+			d.Severity, d.Message = "error", link.url+": "+problem
+		}
+		c.diagnostics = append(c.diagnostics, d)
+	}
+}
+
+// fetchURL returns why u cannot be fetched, or "". HEAD is tried first;
+// servers that refuse it get a GET.
+func fetchURL(client *http.Client, u string) string {
+	if strings.HasPrefix(u, "//") {
+		u = "https:" + u
+	}
+	var status int
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			return err.Error()
+		}
+		req.Header.Set("User-Agent", "mdreader-check")
+		resp, err := client.Do(req)
+		if err != nil {
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			return err.Error()
+		}
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status < 400 {
+			return ""
+		}
+	}
+	return fmt.Sprintf("%d %s", status, http.StatusText(status))
+}
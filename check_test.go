@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// checkDocument runs the link checker on source saved as doc.md next to
+// an existing present.md, and returns the problems found.
+func checkDocument(t *testing.T, source string) []diagnostic {
+	t.Helper()
+	saved := options
+	defer func() { options = saved }()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "present.md"), []byte("# Present\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "doc.md")
+	if err := os.WriteFile(file, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newLinkChecker()
+	if err := c.checkFile(file, dir); err != nil {
+		t.Fatal(err)
+	}
+	return c.diagnostics
+}
+
+func TestCheckSkipsIndentedCode(t *testing.T) {
+	source := "Example:\n\n    [x](missing.md) ![y](missing.png)\n\nText\n    [z](present.md)\n"
+	if problems := checkDocument(t, source); len(problems) != 0 {
+		t.Errorf("problems in indented code: %v", problems)
+	}
+}
+
+func TestCheckSkipsComments(t *testing.T) {
+	source := "<!-- [x](missing.md) -->\n\nText <!-- a\n[y](gone.md)\n--> and [z](present.md#present).\n"
+	if problems := checkDocument(t, source); len(problems) != 0 {
+		t.Errorf("problems in comments: %v", problems)
+	}
+}
+
+func TestCheckWrappedLinkText(t *testing.T) {
+	source := "See [the\nguide](missing.md), [the\nother guide](present.md#nope)\nand [one][\nref].\n\n[not\n\nlinked](missing.md)\n"
+	want := []diagnostic{
+		{Line: 2, Column: 8, Rule: "missing-file"},
+		{Line: 3, Column: 14, Rule: "missing-anchor"},
+		{Line: 4, Column: 5, Rule: "undefined-reference"},
+	}
+	problems := checkDocument(t, source)
+	sortDiagnostics(problems)
+	if len(problems) != len(want) {
+		t.Fatalf("got %v, want %d problems", problems, len(want))
+	}
+	for i, p := range problems {
+		if p.Line != want[i].Line || p.Column != want[i].Column || p.Rule != want[i].Rule {
+			t.Errorf("problem %d = %d:%d %s, want %d:%d %s", i, p.Line, p.Column, p.Rule, want[i].Line, want[i].Column, want[i].Rule)
+		}
+	}
+}
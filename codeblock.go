@@ -0,0 +1,216 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// codeBlockInfo is the parsed info string of a fenced code block, e.g.
+//
+//	```go title="main.go" {3-5,9} linenos start=10
+type codeBlockInfo struct {
+	Lang        string
+	Title       string
+	LineNumbers bool
+	StartLine   int
+	// Highlight lists inclusive line ranges, counted from the first line
+	// of the block regardless of StartLine.
+	Highlight [][2]int
+}
+
+func parseCodeBlockInfo(info string) codeBlockInfo {
+	parsed := codeBlockInfo{StartLine: 1}
+	fields := splitInfoString(info)
+	if len(fields) == 0 {
+		return parsed
+	}
+
+	// The language may carry the ranges directly: go{3-5}.
+	first := fields[0]
+	if i := strings.IndexByte(first, '{'); i > 0 {
+		fields = append([]string{first[:i], first[i:]}, fields[1:]...)
+		first = first[:i]
+	}
+	if !strings.HasPrefix(first, "{") && !strings.Contains(first, "=") {
+		parsed.Lang = strings.ToLower(first)
+		fields = fields[1:]
+	}
+
+	for _, field := range fields {
+		if strings.HasPrefix(field, "{") && strings.HasSuffix(field, "}") {
+			parsed.Highlight = append(parsed.Highlight, parseLineRanges(field[1:len(field)-1])...)
+			continue
+		}
+		key, value, hasValue := strings.Cut(field, "=")
+		key = strings.ToLower(key)
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "title", "filename", "file":
+			parsed.Title = value
+		case "linenos", "linenums", "showlinenumbers", "numberlines":
+			parsed.LineNumbers = !hasValue || value != "false"
+		case "start", "linenostart", "startline", "startfrom":
+			if n, err := strconv.Atoi(value); err == nil {
+				parsed.StartLine = n
+				parsed.LineNumbers = true
+			}
+		case "hl", "hl_lines", "highlight", "mark":
+			parsed.Highlight = append(parsed.Highlight, parseLineRanges(value)...)
+		}
+	}
+	return parsed
+}
+
+// splitInfoString splits on whitespace while keeping quoted values and
+// {...} range lists together.
+func splitInfoString(info string) []string {
+	var fields []string
+	var current strings.Builder
+	var quote rune
+	braces := 0
+	for _, r := range strings.TrimSpace(info) {
+		switch {
+		case quote != 0:
+			current.WriteRune(r)
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			current.WriteRune(r)
+		case r == '{':
+			braces++
+			current.WriteRune(r)
+		case r == '}':
+			braces--
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && braces == 0:
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// parseLineRanges parses "3-5,9" or "3-5 9" into inclusive ranges,
+// skipping anything it does not understand.
+func parseLineRanges(spec string) [][2]int {
+	var ranges [][2]int
+	for _, part := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			continue
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || end < start {
+				continue
+			}
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges
+}
+
+// fencePattern matches the run of backticks or tildes that opens or closes
+// a fenced code block, including one inside a blockquote.
+var fencePattern = regexp.MustCompile("^(?:[ \t]*>)*[ \t]*(`{3,}|~{3,})")
+
+// codeFence follows fenced code blocks through a document line by line.
+// Like the parser, it closes a block only on a fence of the same character
+// that is at least as long as the opening one and has nothing after it, so
+// a ``` line inside a ```` block stays code.
+type codeFence struct {
+	open string // the opening fence, or "" outside a block
+}
+
+// inside reports whether line belongs to a fenced code block, counting the
+// opening and closing fences, and moves past it.
+func (f *codeFence) inside(line string) bool {
+	m := fencePattern.FindStringSubmatch(line)
+	if f.open == "" {
+		if m == nil {
+			return false
+		}
+		f.open = m[1]
+		return true
+	}
+	if m != nil && m[1][0] == f.open[0] && len(m[1]) >= len(f.open) && strings.TrimSpace(line[len(m[0]):]) == "" {
+		f.open = ""
+	}
+	return true
+}
+
+// codeScanner follows the fenced and indented code blocks of a document
+// line by line. An indented block starts with a line indented four or more
+// columns that follows a blank line, heading or fence outside a list; right
+// after paragraph text the same line continues the paragraph.
+type codeScanner struct {
+	fence    codeFence
+	breaks   bool // the previous line ends a paragraph
+	inList   bool
+	indented bool // inside an indented code block
+	// item is whether the last line scanned starts a list item.
+	item bool
+}
+
+func newCodeScanner() *codeScanner {
+	return &codeScanner{breaks: true}
+}
+
+// inside reports whether line belongs to a code block, fences included,
+// and moves past it.
+func (s *codeScanner) inside(line string) bool {
+	s.item = false
+	if s.fence.inside(line) {
+		s.breaks, s.indented = true, false
+		return true
+	}
+	if strings.TrimSpace(line) == "" {
+		s.breaks = true
+		return s.indented
+	}
+	indent := indentWidth(line)
+	if indent >= 4 && (s.indented || (s.breaks && !s.inList)) {
+		s.indented = true
+		return true
+	}
+	// Without a blank line first, a list cannot interrupt a paragraph.
+	s.item = (lintBulletPattern.MatchString(line) || lintOrderedPattern.MatchString(line)) && (s.inList || s.breaks)
+	if indent == 0 && s.breaks && !s.item {
+		s.inList = false
+	}
+	if s.item {
+		s.inList = true
+	}
+	s.breaks = lintATXHeadingPattern.MatchString(line) || lintSetextPattern.MatchString(line)
+	s.indented = false
+	return false
+}
+
+// indentWidth returns the width of the leading whitespace of line, with
+// tabs advancing to the next multiple of four.
+func indentWidth(line string) int {
+	width := 0
+	for _, c := range line {
+		switch c {
+		case ' ':
+			width++
+		case '\t':
+			width += 4 - width%4
+		default:
+			return width
+		}
+	}
+	return width
+}
@@ -0,0 +1,157 @@
+package main
+
+import "fmt"
+
+// codeToolsSettings returns the copy button and collapse settings for a
+// page: command-line options, overridden by the page's front matter.
+func codeToolsSettings(meta FrontMatter) (copyButtons bool, collapseLines int) {
+	copyButtons, collapseLines = options.CopyButtons, options.CollapseCode
+	if meta.CopyButtons != nil {
+		copyButtons = *meta.CopyButtons
+	}
+	if meta.CollapseCode != nil {
+		collapseLines = *meta.CollapseCode
+	}
+	return copyButtons, collapseLines
+}
+
+// getCodeToolsScript returns an inline style and script that add copy
+// buttons to code blocks and collapse long ones, or "" when both are off.
+// It has no dependencies and needs no network.
+func getCodeToolsScript(meta FrontMatter) string {
+	copyButtons, collapseLines := codeToolsSettings(meta)
+	if !copyButtons && collapseLines <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(`<style>%s</style>
+<script>
+%s
+codeTools(window, %t, %d);
+</script>`, codeToolsCSS, codeToolsJS, copyButtons, collapseLines)
+}
+
+// codeToolsJS defines codeTools(win, copyButtons, collapseLines), which
+// adds the tools to the code blocks of the page in win. The UI editor calls
+// it on its preview, where a sandboxed page's own script does not run, so
+// code blocks that already have the tools are skipped.
+const codeToolsJS = `function codeTools(win, copyButtons, collapseLines) {
+    var document = win.document;
+
+    function codeText(pre) {
+        var code = pre.cloneNode(true);
+        code.querySelectorAll('.ln, .lnt, .code-copy').forEach(function (n) { n.remove(); });
+        return code.textContent.replace(/\n$/, '');
+    }
+
+    function copyText(text) {
+        if (win.navigator.clipboard && win.isSecureContext) {
+            return win.navigator.clipboard.writeText(text);
+        }
+        return new Promise(function (resolve, reject) {
+            var area = document.createElement('textarea');
+            area.value = text;
+            area.style.position = 'fixed';
+            area.style.opacity = '0';
+            document.body.appendChild(area);
+            area.select();
+            var ok = document.execCommand('copy');
+            document.body.removeChild(area);
+            ok ? resolve() : reject();
+        });
+    }
+
+    function addCopyButton(pre) {
+        var button = document.createElement('button');
+        button.type = 'button';
+        button.className = 'code-copy';
+        button.textContent = 'Copy';
+        button.setAttribute('aria-label', 'Copy code to clipboard');
+        button.addEventListener('click', function () {
+            copyText(codeText(pre)).then(function () {
+                button.textContent = 'Copied';
+            }, function () {
+                button.textContent = 'Failed';
+            });
+            setTimeout(function () { button.textContent = 'Copy'; }, 1500);
+        });
+        pre.appendChild(button);
+    }
+
+    function addCollapseToggle(pre) {
+        var lines = codeText(pre).split('\n').length;
+        if (lines <= collapseLines) return;
+
+        var lineHeight = parseFloat(win.getComputedStyle(pre).lineHeight) || 20;
+        pre.classList.add('code-collapsed');
+        pre.style.maxHeight = (collapseLines * lineHeight) + 'px';
+
+        var toggle = document.createElement('button');
+        toggle.type = 'button';
+        toggle.className = 'code-expand';
+        toggle.textContent = 'Show all ' + lines + ' lines';
+        toggle.addEventListener('click', function () {
+            var collapsed = pre.classList.toggle('code-collapsed');
+            pre.style.maxHeight = collapsed ? (collapseLines * lineHeight) + 'px' : '';
+            toggle.textContent = collapsed ? 'Show all ' + lines + ' lines' : 'Show less';
+        });
+        pre.parentNode.insertBefore(toggle, pre.nextSibling);
+    }
+
+    document.querySelectorAll('.markdown-body pre').forEach(function (pre) {
+        if (pre.classList.contains('code-tools')) return;
+        pre.classList.add('code-tools');
+        if (copyButtons) addCopyButton(pre);
+        if (collapseLines > 0) addCollapseToggle(pre);
+    });
+}`
+
+const codeToolsCSS = `
+        .markdown-body pre.code-tools {
+            position: relative;
+        }
+
+        .markdown-body .code-copy {
+            position: absolute;
+            top: 8px;
+            right: 8px;
+            padding: 2px 8px;
+            font-size: 12px;
+            color: #57606a;
+            background-color: #f6f8fa;
+            border: 1px solid #d0d7de;
+            border-radius: 6px;
+            cursor: pointer;
+            opacity: 0;
+            transition: opacity 0.2s;
+        }
+
+        .markdown-body pre:hover .code-copy,
+        .markdown-body .code-copy:focus {
+            opacity: 1;
+        }
+
+        .markdown-body pre.code-collapsed {
+            overflow: hidden;
+            margin-bottom: 0;
+            border-bottom-left-radius: 0;
+            border-bottom-right-radius: 0;
+        }
+
+        .markdown-body .code-expand {
+            display: block;
+            width: 100%;
+            margin-bottom: 16px;
+            padding: 4px;
+            font-size: 12px;
+            color: #0366d6;
+            background-color: #eaeef2;
+            border: 0;
+            border-radius: 0 0 6px 6px;
+            cursor: pointer;
+        }
+
+        .markdown-body pre:not(.code-collapsed) + .code-expand {
+            margin-top: -16px;
+        }
+`
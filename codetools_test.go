@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// codeToolsDOM is just enough of a DOM for the code tools script: one code
+// block with line numbers in a .markdown-body, a clipboard that records
+// what is written to it, and no timers.
+const codeToolsDOM = `
+function El(tag, className, text) {
+    var el = this;
+    this.tagName = tag;
+    this.className = className || '';
+    this.text = text || '';
+    this.children = [];
+    this.parentNode = null;
+    this.listeners = {};
+    this.style = {};
+    this.classList = {
+        has: function (c) { return (' ' + el.className + ' ').indexOf(' ' + c + ' ') >= 0; },
+        contains: function (c) { return this.has(c); },
+        add: function (c) { if (!this.has(c)) el.className = (el.className + ' ' + c).trim(); },
+        toggle: function (c) {
+            if (this.has(c)) {
+                el.className = (' ' + el.className + ' ').replace(' ' + c + ' ', ' ').trim();
+                return false;
+            }
+            this.add(c);
+            return true;
+        }
+    };
+}
+El.prototype = {
+    get textContent() {
+        return this.text + this.children.map(function (c) { return c.textContent; }).join('');
+    },
+    set textContent(value) { this.text = value; this.children = []; },
+    get nextSibling() {
+        var siblings = this.parentNode.children;
+        return siblings[siblings.indexOf(this) + 1] || null;
+    },
+    appendChild: function (child) { child.parentNode = this; this.children.push(child); return child; },
+    insertBefore: function (child, ref) {
+        child.parentNode = this;
+        var i = ref ? this.children.indexOf(ref) : -1;
+        i < 0 ? this.children.push(child) : this.children.splice(i, 0, child);
+        return child;
+    },
+    remove: function () { this.parentNode.children.splice(this.parentNode.children.indexOf(this), 1); },
+    cloneNode: function () {
+        var copy = new El(this.tagName, this.className, this.text);
+        this.children.forEach(function (c) { copy.appendChild(c.cloneNode(true)); });
+        return copy;
+    },
+    querySelectorAll: function (selector) {
+        var classes = selector.split(',').map(function (s) { return s.trim().replace(/^\./, ''); });
+        var found = [];
+        (function walk(el) {
+            el.children.forEach(function (c) {
+                if (classes.some(function (k) { return c.classList.has(k); })) found.push(c);
+                walk(c);
+            });
+        })(this);
+        return found;
+    },
+    setAttribute: function () {},
+    addEventListener: function (type, listener) { this.listeners[type] = listener; }
+};
+
+var body = new El('div', 'markdown-body');
+var pre = body.appendChild(new El('pre'));
+var code = pre.appendChild(new El('code'));
+[['1', 'echo one\n'], ['2', 'echo two\n']].forEach(function (line) {
+    code.appendChild(new El('span', 'ln', line[0]));
+    code.appendChild(new El('span', 'cl', line[1]));
+});
+
+var copied = null;
+globalThis.window = globalThis;
+globalThis.isSecureContext = true;
+Object.defineProperty(globalThis, 'navigator', {
+    value: {clipboard: {writeText: function (text) { copied = text; return Promise.resolve(); }}},
+    configurable: true
+});
+globalThis.getComputedStyle = function () { return {lineHeight: '20px'}; };
+globalThis.setTimeout = function () {};
+globalThis.document = {
+    querySelectorAll: function () { return [pre]; },
+    createElement: function (tag) { return new El(tag); }
+};
+`
+
+// codeToolsReport clicks the copy button and prints what was copied and
+// the label of the expand toggle.
+const codeToolsReport = `
+pre.querySelectorAll('.code-copy')[0].listeners.click();
+console.log(JSON.stringify({copied: copied, toggle: body.querySelectorAll('.code-expand')[0].textContent}));
+`
+
+// runCodeTools runs the code tools script of a page with copy buttons and
+// blocks collapsed beyond one line on codeToolsDOM, followed by report,
+// and returns what it prints.
+func runCodeTools(t *testing.T, report string) []byte {
+	t.Helper()
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	saved := options
+	defer func() { options = saved }()
+	options.CopyButtons = true
+	options.CollapseCode = 1
+
+	tools := getCodeToolsScript(FrontMatter{})
+	start := strings.Index(tools, "<script>")
+	end := strings.LastIndex(tools, "</script>")
+	if start < 0 || end < start {
+		t.Fatalf("no script in code tools:\n%s", tools)
+	}
+	script := codeToolsDOM + tools[start+len("<script>"):end] + report
+
+	out, err := exec.Command(node, "-e", script).CombinedOutput()
+	if err != nil {
+		t.Fatalf("node: %v\n%s", err, out)
+	}
+	return out
+}
+
+func TestCodeToolsCopyText(t *testing.T) {
+	out := runCodeTools(t, codeToolsReport)
+	var got struct {
+		Copied string
+		Toggle string
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("unexpected output %q: %v", out, err)
+	}
+	if want := "echo one\necho two"; got.Copied != want {
+		t.Errorf("copied %q, want %q", got.Copied, want)
+	}
+	if want := "Show all 2 lines"; got.Toggle != want {
+		t.Errorf("toggle says %q, want %q", got.Toggle, want)
+	}
+}
+
+// The UI editor runs codeTools on a preview whose own script may already
+// have run; the tools must not be added twice.
+func TestCodeToolsRunTwice(t *testing.T) {
+	out := runCodeTools(t, `
+codeTools(window, true, 1);
+console.log(body.querySelectorAll('.code-copy, .code-expand').length);
+`)
+	if got := strings.TrimSpace(string(out)); got != "2" {
+		t.Errorf("got %s copy buttons and toggles, want 2", got)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileName is looked up from the input file's directory upwards when
+// --config is not given.
+const configFileName = ".mdreader.yaml"
+
+// Config is the project configuration file. Command-line flags override
+// any value set here.
+type Config struct {
+	DetectLanguage  *bool             `yaml:"detect_language"`
+	LanguageAliases map[string]string `yaml:"language_aliases"`
+
+	// SafePolicy is a sanitize policy file, relative to the config file.
+	SafePolicy string `yaml:"safe_policy"`
+
+	// BaseURL is the address a site is published at. The sitemap and
+	// feeds need it for absolute URLs.
+	BaseURL string     `yaml:"base_url"`
+	Feed    FeedConfig `yaml:"feed"`
+
+	// Lint configures the rules of mdreader lint, by rule name or
+	// markdownlint ID.
+	Lint map[string]LintRuleConfig `yaml:"lint"`
+	// Format sets the style of mdreader fmt.
+	Format FormatConfig `yaml:"format"`
+}
+
+// FeedConfig controls the feed of dated pages written by mdreader build.
+type FeedConfig struct {
+	Title  string `yaml:"title"`
+	Author string `yaml:"author"`
+	Limit  *int   `yaml:"limit"`  // newest entries to include, default 20, 0 for all
+	Format string `yaml:"format"` // "atom" (default), "rss" or "both"
+}
+
+// FormatConfig controls mdreader fmt.
+type FormatConfig struct {
+	Width int `yaml:"width"` // reflow paragraphs to this width, 0 (default) keeps their lines
+}
+
+// LintRuleConfig configures one lint rule. A rule can also be set to
+// false to turn it off.
+type LintRuleConfig struct {
+	Disabled bool   `yaml:"-"`
+	Severity string `yaml:"severity"` // "warning" (default), "error" or "info"
+	Max      int    `yaml:"max"`      // line-length: longest line allowed, default 80
+	Style    string `yaml:"style"`    // ul-style: "consistent" (default), "dash", "asterisk" or "plus"
+}
+
+func (c *LintRuleConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var enabled bool
+		if err := node.Decode(&enabled); err != nil {
+			return err
+		}
+		c.Disabled = !enabled
+		return nil
+	}
+	type plain LintRuleConfig
+	var fields struct {
+		Enabled *bool `yaml:"enabled"`
+	}
+	if err := node.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	if err := node.Decode(&fields); err != nil {
+		return err
+	}
+	c.Disabled = fields.Enabled != nil && !*fields.Enabled
+	return nil
+}
+
+func loadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// findConfig walks up from dir and returns the first config file found, or
+// "" if there is none.
+func findConfig(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, configFileName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// applyConfig copies config values into options, skipping any option whose
+// flag was set explicitly on the command line.
+func applyConfig(cfg Config, setFlags map[string]bool) {
+	if cfg.DetectLanguage != nil && !setFlags["detect-language"] {
+		options.DetectLanguage = *cfg.DetectLanguage
+	}
+	for alias, lang := range cfg.LanguageAliases {
+		if options.LanguageAliases == nil {
+			options.LanguageAliases = map[string]string{}
+		}
+		options.LanguageAliases[strings.ToLower(alias)] = strings.ToLower(lang)
+	}
+}
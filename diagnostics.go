@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// diagnostic is a problem found at a position in a Markdown file. Lines
+// and columns count from 1; columns count characters, not bytes.
+type diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"` // "error", "warning" or "info"
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+}
+
+func (d diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s (%s)", d.File, d.Line, d.Column, d.Severity, d.Message, d.Rule)
+}
+
+// sortDiagnostics orders diagnostics by file and position.
+func sortDiagnostics(diagnostics []diagnostic) {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i], diagnostics[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+// writeDiagnostics prints diagnostics one per line as file:line:col, or
+// as a JSON array.
+func writeDiagnostics(w io.Writer, format string, diagnostics []diagnostic) error {
+	if format == "json" {
+		if diagnostics == nil {
+			diagnostics = []diagnostic{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diagnostics)
+	}
+	for _, d := range diagnostics {
+		if _, err := fmt.Fprintln(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countSeverity returns how many diagnostics have the given severity.
+func countSeverity(diagnostics []diagnostic, severity string) int {
+	n := 0
+	for _, d := range diagnostics {
+		if d.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// validFormat reports whether format is one writeDiagnostics knows.
+func validFormat(format string) bool {
+	return format == "text" || format == "json"
+}
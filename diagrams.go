@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiagramRenderer turns the source of a diagram fence into SVG.
+type DiagramRenderer interface {
+	RenderSVG(ctx context.Context, source string) ([]byte, error)
+}
+
+// commandDiagramRenderer runs a local tool. The command may reference
+// {input} and {output}; when it does, the source and result go through
+// temporary files, otherwise through stdin and stdout.
+type commandDiagramRenderer struct {
+	Command string
+}
+
+func (c commandDiagramRenderer) RenderSVG(ctx context.Context, source string) ([]byte, error) {
+	args := strings.Fields(c.Command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty diagram command")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, fmt.Errorf("%s not found", args[0])
+	}
+
+	var tmpDir, outputPath string
+	usesFiles := strings.Contains(c.Command, "{input}") || strings.Contains(c.Command, "{output}")
+	if usesFiles {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "mdreader-diagram-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmpDir)
+
+		inputPath := filepath.Join(tmpDir, "input")
+		outputPath = filepath.Join(tmpDir, "output.svg")
+		if err := os.WriteFile(inputPath, []byte(source), 0644); err != nil {
+			return nil, err
+		}
+		for i, arg := range args {
+			arg = strings.ReplaceAll(arg, "{input}", inputPath)
+			args[i] = strings.ReplaceAll(arg, "{output}", outputPath)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if !usesFiles || !strings.Contains(c.Command, "{input}") {
+		cmd.Stdin = strings.NewReader(source)
+	}
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%s timed out", args[0])
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("%s failed: %s", args[0], msg)
+	}
+
+	if outputPath != "" && strings.Contains(c.Command, "{output}") {
+		return os.ReadFile(outputPath)
+	}
+	return stdout.Bytes(), nil
+}
+
+// defaultDiagramCommands returns the tools tried for each diagram language
+// when no --diagram-cmd override is given.
+func defaultDiagramCommands() map[string]string {
+	commands := map[string]string{
+		"dot":      "dot -Tsvg",
+		"graphviz": "dot -Tsvg",
+		"mermaid":  "mmdc --quiet -i {input} -o {output}",
+		"plantuml": "plantuml -tsvg -pipe",
+	}
+	if _, err := exec.LookPath("plantuml"); err != nil {
+		if jar := os.Getenv("PLANTUML_JAR"); jar != "" {
+			commands["plantuml"] = "java -jar " + jar + " -tsvg -pipe"
+		}
+	}
+	return commands
+}
+
+var (
+	diagramRenderersMu sync.RWMutex
+	diagramRenderers   = map[string]DiagramRenderer{}
+)
+
+// registerDiagramRenderer makes fences tagged with lang render through r.
+func registerDiagramRenderer(lang string, r DiagramRenderer) {
+	diagramRenderersMu.Lock()
+	defer diagramRenderersMu.Unlock()
+	diagramRenderers[lang] = r
+}
+
+func lookupDiagramRenderer(lang string) (DiagramRenderer, bool) {
+	diagramRenderersMu.RLock()
+	defer diagramRenderersMu.RUnlock()
+	r, ok := diagramRenderers[lang]
+	return r, ok
+}
+
+// setupDiagramRenderers registers a command renderer for every default
+// language, with overrides from --diagram-cmd taking precedence.
+func setupDiagramRenderers(overrides map[string]string) {
+	commands := defaultDiagramCommands()
+	for lang, command := range overrides {
+		commands[lang] = command
+	}
+	for lang, command := range commands {
+		registerDiagramRenderer(lang, commandDiagramRenderer{Command: command})
+	}
+}
+
+const (
+	// maxCachedDiagrams bounds the diagrams kept in memory, and
+	// maxDiskDiagrams the SVG files kept in the cache directory; the least
+	// recently used go first.
+	maxCachedDiagrams = 256
+	maxDiskDiagrams   = 1000
+)
+
+// diagramResult is a rendered diagram, or why its tool failed.
+type diagramResult struct {
+	svg []byte
+	err error
+}
+
+// diagramCache keeps rendered SVG by content hash in memory and, when a
+// user cache directory is available, on disk across runs. Failures are
+// only kept in memory, so a broken diagram is not retried on every
+// preview.
+var diagramCache = struct {
+	sync.Mutex
+	entries map[string]diagramResult
+	order   []string // keys of entries, least recently used first
+}{entries: map[string]diagramResult{}}
+
+func diagramCacheKey(lang string, r DiagramRenderer, source string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%#v\x00%s", lang, r, source)))
+	return hex.EncodeToString(sum[:])
+}
+
+func diagramCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mdreader", "diagrams")
+}
+
+func cachedDiagram(key string) (diagramResult, bool) {
+	diagramCache.Lock()
+	defer diagramCache.Unlock()
+	if result, ok := diagramCache.entries[key]; ok {
+		rememberDiagram(key, result)
+		return result, true
+	}
+	if dir := diagramCacheDir(); dir != "" {
+		file := filepath.Join(dir, key+".svg")
+		if svg, err := os.ReadFile(file); err == nil {
+			now := time.Now()
+			os.Chtimes(file, now, now)
+			rememberDiagram(key, diagramResult{svg: svg})
+			return diagramResult{svg: svg}, true
+		}
+	}
+	return diagramResult{}, false
+}
+
+func storeDiagram(key string, result diagramResult) {
+	diagramCache.Lock()
+	defer diagramCache.Unlock()
+	rememberDiagram(key, result)
+	if dir := diagramCacheDir(); dir != "" && result.err == nil {
+		if err := os.MkdirAll(dir, 0755); err == nil {
+			os.WriteFile(filepath.Join(dir, key+".svg"), result.svg, 0644)
+			pruneDiagramCache(dir, maxDiskDiagrams)
+		}
+	}
+}
+
+// rememberDiagram puts result in the memory cache as the most recently
+// used entry, evicting the least recently used beyond maxCachedDiagrams.
+// The caller holds diagramCache.
+func rememberDiagram(key string, result diagramResult) {
+	for i, k := range diagramCache.order {
+		if k == key {
+			diagramCache.order = append(diagramCache.order[:i], diagramCache.order[i+1:]...)
+			break
+		}
+	}
+	diagramCache.entries[key] = result
+	diagramCache.order = append(diagramCache.order, key)
+	for len(diagramCache.order) > maxCachedDiagrams {
+		delete(diagramCache.entries, diagramCache.order[0])
+		diagramCache.order = diagramCache.order[1:]
+	}
+}
+
+// pruneDiagramCache removes all but the keep most recently used SVG files
+// from the cache directory.
+func pruneDiagramCache(dir string, keep int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	type cached struct {
+		name string
+		used time.Time
+	}
+	var files []cached
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() && strings.HasSuffix(entry.Name(), ".svg") {
+			files = append(files, cached{entry.Name(), info.ModTime()})
+		}
+	}
+	if len(files) <= keep {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].used.After(files[j].used) })
+	for _, f := range files[keep:] {
+		os.Remove(filepath.Join(dir, f.name))
+	}
+}
+
+// diagramJob is a diagram missing from the cache.
+type diagramJob struct {
+	lang, key, source string
+	renderer          DiagramRenderer
+}
+
+// run renders the diagram with the configured timeout and caches the
+// result, including a failure.
+func (job diagramJob) run() diagramResult {
+	if result, ok := cachedDiagram(job.key); ok {
+		return result
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), options.DiagramTimeout)
+	defer cancel()
+
+	svg, err := job.renderer.RenderSVG(ctx, job.source)
+	if err == nil && !bytes.Contains(svg, []byte("<svg")) {
+		err = fmt.Errorf("renderer produced no SVG")
+	}
+	result := diagramResult{svg: svg, err: err}
+	storeDiagram(job.key, result)
+	return result
+}
+
+// deferredDiagrams, when not nil, collects the diagrams missing from the
+// cache instead of running their tools there and then. The UI sets it
+// while holding previewMu and runs the jobs once the lock is released.
+var deferredDiagrams *[]diagramJob
+
+var svgPrologPattern = regexp.MustCompile(`(?s)^.*?(<svg[\s>])`)
+
+// renderDiagram returns the fence as inline SVG, or the highlighted source
+// with a warning when no renderer is available or it fails.
+func renderDiagram(lang, source string) (string, bool) {
+	r, ok := lookupDiagramRenderer(lang)
+	if !ok {
+		return "", false
+	}
+
+	job := diagramJob{lang: lang, key: diagramCacheKey(lang, r, source), source: source, renderer: r}
+	result, ok := cachedDiagram(job.key)
+	if !ok {
+		if deferredDiagrams != nil {
+			*deferredDiagrams = append(*deferredDiagrams, job)
+			return highlightCode(source, lang), true
+		}
+		result = job.run()
+	}
+	if result.err != nil {
+		warning := fmt.Sprintf("%s diagram not rendered: %v", lang, result.err)
+		warnOnce(warning)
+		return fmt.Sprintf(`<div class="diagram-warning">%s</div>`, template.HTMLEscapeString(warning)) +
+			highlightCode(source, lang), true
+	}
+
+	// Drop any XML declaration or DOCTYPE before the <svg> element.
+	svg := svgPrologPattern.ReplaceAll(result.svg, []byte("$1"))
+	return fmt.Sprintf("<div class=\"diagram diagram-%s\">%s</div>\n", lang, bytes.TrimSpace(svg)), true
+}
+
+// diagramCommandFlags collects repeated --diagram-cmd lang=command flags.
+type diagramCommandFlags map[string]string
+
+func (f diagramCommandFlags) String() string {
+	parts := make([]string, 0, len(f))
+	for lang, command := range f {
+		parts = append(parts, lang+"="+command)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (f diagramCommandFlags) Set(value string) error {
+	lang, command, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(lang) == "" || strings.TrimSpace(command) == "" {
+		return fmt.Errorf("expected lang=command, got %q", value)
+	}
+	f[strings.ToLower(strings.TrimSpace(lang))] = strings.TrimSpace(command)
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingRenderer counts its runs and notes any made while previewMu is
+// held. With no svg it fails.
+type countingRenderer struct {
+	svg    string
+	calls  *int
+	locked *bool
+}
+
+func (r countingRenderer) RenderSVG(ctx context.Context, source string) ([]byte, error) {
+	*r.calls++
+	if previewMu.TryLock() {
+		previewMu.Unlock()
+	} else {
+		*r.locked = true
+	}
+	if r.svg == "" {
+		return nil, fmt.Errorf("broken")
+	}
+	return []byte(r.svg), nil
+}
+
+// useDiagramRenderer registers r for lang for the rest of the test, with
+// the disk cache in a temporary directory.
+func useDiagramRenderer(t *testing.T, lang string, r DiagramRenderer) {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	registerDiagramRenderer(lang, r)
+	t.Cleanup(func() {
+		diagramRenderersMu.Lock()
+		delete(diagramRenderers, lang)
+		diagramRenderersMu.Unlock()
+	})
+}
+
+func TestPreviewRunsDiagramsOutsideLock(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.Diagrams = true
+	ws := &workspace{root: t.TempDir()}
+
+	for _, tc := range []struct {
+		svg, want string
+	}{
+		{`<svg id="d"><rect width="1" height="1"></rect></svg>`, `<svg id="d">`},
+		{"", "test diagram not rendered: broken"},
+	} {
+		var calls int
+		var locked bool
+		useDiagramRenderer(t, "test", countingRenderer{svg: tc.svg, calls: &calls, locked: &locked})
+
+		for i := 0; i < 2; i++ {
+			if html := ws.convertPreview("", "```test\nA -> B\n```\n"); !strings.Contains(html, tc.want) {
+				t.Errorf("preview %d does not contain %q:\n%s", i+1, tc.want, html)
+			}
+		}
+		if calls != 1 {
+			t.Errorf("diagram tool ran %d times for two previews, want 1", calls)
+		}
+		if locked {
+			t.Error("diagram tool ran while previewMu was held")
+		}
+	}
+}
+
+func TestFormatDoesNotRunDiagrams(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.Diagrams = true
+
+	var calls int
+	var locked bool
+	useDiagramRenderer(t, "test", countingRenderer{calls: &calls, locked: &locked})
+
+	source := "# Diagram\n\n```test\nA -> B\n```\n"
+	if got := string(formatMarkdown([]byte(source), formatStyle{bullet: '-'})); got != source {
+		t.Errorf("formatted to %q, want %q", got, source)
+	}
+	if calls != 0 {
+		t.Errorf("formatting ran the diagram tool %d times", calls)
+	}
+}
+
+func TestDiagramMemoryCacheIsBounded(t *testing.T) {
+	diagramCache.Lock()
+	defer diagramCache.Unlock()
+	entries, order := diagramCache.entries, diagramCache.order
+	defer func() { diagramCache.entries, diagramCache.order = entries, order }()
+	diagramCache.entries, diagramCache.order = map[string]diagramResult{}, nil
+
+	for i := 0; i < maxCachedDiagrams+10; i++ {
+		rememberDiagram(fmt.Sprint(i), diagramResult{})
+		if i == maxCachedDiagrams-1 {
+			rememberDiagram("0", diagramResult{}) // used again, so kept
+		}
+	}
+	if len(diagramCache.entries) != maxCachedDiagrams || len(diagramCache.order) != maxCachedDiagrams {
+		t.Fatalf("cache holds %d entries in order %d, want %d", len(diagramCache.entries), len(diagramCache.order), maxCachedDiagrams)
+	}
+	if _, ok := diagramCache.entries["0"]; !ok {
+		t.Error("recently used entry was evicted")
+	}
+	if _, ok := diagramCache.entries["1"]; ok {
+		t.Error("least recently used entry was kept")
+	}
+}
+
+func TestPruneDiagramCache(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"a.svg", "b.svg", "c.svg", "d.svg", "notes.txt"} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte("<svg></svg>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		used := now.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(file, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pruneDiagramCache(dir, 2)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	if got, want := strings.Join(names, " "), "c.svg d.svg notes.txt"; got != want {
+		t.Errorf("left %s, want %s", got, want)
+	}
+}
+
+func TestSanitizeKeepsScopedSVGStyle(t *testing.T) {
+	body := `<div class="diagram"><svg id="m1"><style>` +
+		`#m1{font-family:"trebuchet ms";fill:#333}` +
+		`#m1 .node rect,#m1>g{stroke:blue}` +
+		`body{display:none}` +
+		`#m1 ~ p{color:red}` +
+		`#m10 g{fill:red}` +
+		`#m1 .cluster, p{fill:red}` +
+		`#m1 g{background:url(https://example.com/x)}` +
+		`@keyframes dash{to{stroke-dashoffset:0}}` +
+		`</style><g></g></svg></div>` +
+		`<style>p{color:red}</style><svg><style>g{fill:red}</style></svg>`
+	want := `<div class="diagram"><svg id="m1"><style>` +
+		`#m1{font-family:&#34;trebuchet ms&#34;;fill:#333}` +
+		`#m1 .node rect,#m1&gt;g{stroke:blue}` +
+		`</style><g></g></svg></div><svg></svg>`
+	if got := sanitizeHTML(body, defaultSanitizePolicy()); got != want {
+		t.Errorf("sanitized to\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+)
+
+// diffLine classifies one line of a diff-<lang> code block.
+type diffLine int
+
+const (
+	diffContext diffLine = iota
+	diffAdded
+	diffRemoved
+	diffHunk
+	diffHeader
+)
+
+var diffLineClasses = map[diffLine]string{
+	diffContext: "diff-context",
+	diffAdded:   "diff-add",
+	diffRemoved: "diff-del",
+	diffHunk:    "diff-hunk",
+	diffHeader:  "diff-header",
+}
+
+var diffLineMarkers = map[diffLine]string{
+	diffContext: " ",
+	diffAdded:   "+",
+	diffRemoved: "-",
+}
+
+// diffLanguage extracts the embedded language from "diff-go" or
+// "diff:python". Plain "diff" keeps chroma's own diff lexer.
+func diffLanguage(lang string) (string, bool) {
+	for _, prefix := range []string{"diff-", "diff:"} {
+		if strings.HasPrefix(lang, prefix) && len(lang) > len(prefix) {
+			return lang[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+// stripDiffMarkers removes the +/-/space column so the code can be
+// highlighted with its real lexer, and reports what each line was.
+// File headers and hunk headers are kept verbatim.
+func stripDiffMarkers(code string) (string, []diffLine) {
+	lines := strings.Split(strings.TrimSuffix(code, "\n"), "\n")
+	kinds := make([]diffLine, len(lines))
+	inHunk := false
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			kinds[i] = diffHunk
+			inHunk = true
+		case !inHunk && (strings.HasPrefix(line, "+++ ") || strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "diff ") || strings.HasPrefix(line, "index ")):
+			kinds[i] = diffHeader
+		case strings.HasPrefix(line, "+"):
+			kinds[i] = diffAdded
+			lines[i] = line[1:]
+		case strings.HasPrefix(line, "-"):
+			kinds[i] = diffRemoved
+			lines[i] = line[1:]
+		case strings.HasPrefix(line, " "):
+			kinds[i] = diffContext
+			lines[i] = line[1:]
+		default:
+			kinds[i] = diffContext
+		}
+	}
+	return strings.Join(lines, "\n") + "\n", kinds
+}
+
+// applyDiffLines adds the diff classes and markers back onto the
+// per-line spans of chroma's output.
+func applyDiffLines(highlighted string, kinds []diffLine) string {
+	const lineOpen = `<span class="line`
+
+	var out strings.Builder
+	rest := highlighted
+	for i := 0; ; i++ {
+		pos := strings.Index(rest, lineOpen)
+		if pos < 0 || i >= len(kinds) {
+			break
+		}
+		tagEnd := strings.IndexByte(rest[pos:], '>')
+		if tagEnd < 0 {
+			break
+		}
+		tagEnd += pos
+
+		out.WriteString(rest[:pos+len(lineOpen)])
+		out.WriteString(" " + diffLineClasses[kinds[i]])
+		out.WriteString(rest[pos+len(lineOpen) : tagEnd+1])
+		if marker, ok := diffLineMarkers[kinds[i]]; ok {
+			out.WriteString(`<span class="diff-marker">` + marker + `</span>`)
+		}
+		rest = rest[tagEnd+1:]
+	}
+	out.WriteString(rest)
+	return out.String()
+}
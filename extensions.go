@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// Extension identifies an optional Markdown syntax feature that can be
+// switched on or off with --extensions.
+type Extension int
+
+const (
+	ExtFootnotes Extension = 1 << iota
+	ExtDefinitionLists
+	ExtAbbreviations
+	ExtHighlight
+	ExtSuperscript
+	ExtSubscript
+	ExtMath
+	ExtWikiLinks
+	ExtIncludes
+
+	ExtNone Extension = 0
+	ExtAll            = ExtFootnotes | ExtDefinitionLists | ExtAbbreviations | ExtHighlight | ExtSuperscript | ExtSubscript | ExtMath | ExtWikiLinks | ExtIncludes
+)
+
+var extensionNames = map[string]Extension{
+	"footnotes":        ExtFootnotes,
+	"definition-lists": ExtDefinitionLists,
+	"abbreviations":    ExtAbbreviations,
+	"highlight":        ExtHighlight,
+	"superscript":      ExtSuperscript,
+	"subscript":        ExtSubscript,
+	"math":             ExtMath,
+	"wikilinks":        ExtWikiLinks,
+	"includes":         ExtIncludes,
+}
+
+// parseExtensions applies a comma-separated list such as
+// "none,footnotes,highlight" or "-subscript" to base. A bare or "+"-prefixed
+// name enables a feature, a "-"-prefixed name disables it, and "all" and
+// "none" reset the whole set.
+func parseExtensions(spec string, base Extension) (Extension, error) {
+	exts := base
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		switch item {
+		case "all":
+			exts = ExtAll
+			continue
+		case "none":
+			exts = ExtNone
+			continue
+		}
+
+		disable := strings.HasPrefix(item, "-")
+		name := strings.TrimLeft(item, "+-")
+		ext, ok := extensionNames[name]
+		if !ok {
+			return base, fmt.Errorf("unknown extension %q (available: %s)", name, strings.Join(extensionList(), ", "))
+		}
+		if disable {
+			exts &^= ext
+		} else {
+			exts |= ext
+		}
+	}
+	return exts, nil
+}
+
+func extensionList() []string {
+	names := make([]string, 0, len(extensionNames))
+	for name := range extensionNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parserExtensions returns the blackfriday extensions backing exts.
+func parserExtensions(exts Extension) blackfriday.Extensions {
+	var flags blackfriday.Extensions
+	if exts&ExtFootnotes != 0 {
+		flags |= blackfriday.Footnotes
+	}
+	if exts&ExtDefinitionLists != 0 {
+		flags |= blackfriday.DefinitionLists
+	}
+	return flags
+}
+
+// documentExtensions returns the parser flags documents are read with: the
+// common set plus whatever the enabled --extensions add.
+func documentExtensions() blackfriday.Extensions {
+	extensions := blackfriday.CommonExtensions | blackfriday.AutoHeadingIDs | blackfriday.FencedCode | blackfriday.Tables
+	return extensions&^parserExtensions(ExtAll) | parserExtensions(options.Extensions)
+}
+
+var abbreviationPattern = regexp.MustCompile(`^\*\[([^\]]+)\]:\s*(.*)$`)
+
+// extractAbbreviations removes "*[ABBR]: Full text" definitions from the
+// document and returns them. Definition lines are blanked rather than
+// dropped so source line numbers stay stable.
+func extractAbbreviations(markdown []byte) ([]byte, map[string]string) {
+	abbrs := map[string]string{}
+	var out bytes.Buffer
+	code := newCodeScanner()
+	scanner := bufio.NewScanner(bytes.NewReader(markdown))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(markdown)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case code.inside(line):
+		default:
+			if m := abbreviationPattern.FindStringSubmatch(line); m != nil {
+				abbrs[strings.TrimSpace(m[1])] = strings.TrimSpace(m[2])
+				line = ""
+			}
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if len(abbrs) == 0 {
+		return markdown, nil
+	}
+	return out.Bytes(), abbrs
+}
+
+var (
+	highlightPattern   = regexp.MustCompile(`==([^=\s](?:[^=]*[^=\s])?)==`)
+	superscriptPattern = regexp.MustCompile(`\^([^\s^]+)\^`)
+	subscriptPattern   = regexp.MustCompile(`~([^\s~]+)~`)
+)
+
+// applyInlineExtensions rewrites already escaped text output with the
+// enabled inline extensions. It only ever sees the HTML produced for a
+// single Text node, so it cannot touch markup generated elsewhere.
+func (r *CustomHTMLRenderer) applyInlineExtensions(text []byte) []byte {
+	if r.extensions&ExtHighlight != 0 {
+		text = highlightPattern.ReplaceAll(text, []byte("<mark>$1</mark>"))
+	}
+	if r.extensions&ExtSuperscript != 0 {
+		text = superscriptPattern.ReplaceAll(text, []byte("<sup>$1</sup>"))
+	}
+	if r.extensions&ExtSubscript != 0 {
+		text = subscriptPattern.ReplaceAll(text, []byte("<sub>$1</sub>"))
+	}
+	if r.extensions&ExtAbbreviations != 0 && len(r.abbreviations) > 0 {
+		text = r.wrapAbbreviations(text)
+	}
+	return text
+}
+
+func (r *CustomHTMLRenderer) wrapAbbreviations(text []byte) []byte {
+	if r.abbreviationPattern == nil {
+		keys := make([]string, 0, len(r.abbreviations))
+		for abbr := range r.abbreviations {
+			keys = append(keys, regexp.QuoteMeta(template.HTMLEscapeString(abbr)))
+		}
+		// Longest first so "HTML5" wins over "HTML".
+		sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+		r.abbreviationPattern = regexp.MustCompile(strings.Join(keys, "|"))
+	}
+
+	var out bytes.Buffer
+	last := 0
+	for _, loc := range r.abbreviationPattern.FindAllIndex(text, -1) {
+		if !isWordBoundary(text, loc[0], loc[1]) {
+			continue
+		}
+		word := string(text[loc[0]:loc[1]])
+		title := r.abbreviations[unescapeHTMLText(word)]
+		out.Write(text[last:loc[0]])
+		fmt.Fprintf(&out, `<abbr title="%s">%s</abbr>`, template.HTMLEscapeString(title), word)
+		last = loc[1]
+	}
+	if last == 0 {
+		return text
+	}
+	out.Write(text[last:])
+	return out.Bytes()
+}
+
+func isWordBoundary(text []byte, start, end int) bool {
+	if start > 0 {
+		prev, _ := utf8.DecodeLastRune(text[:start])
+		if isWordRune(prev) {
+			return false
+		}
+	}
+	if end < len(text) {
+		next, _ := utf8.DecodeRune(text[end:])
+		if isWordRune(next) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func unescapeHTMLText(s string) string {
+	return strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&#34;", `"`, "&quot;", `"`, "&#39;", "'").Replace(s)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultFeedLimit = 20
+
+// absoluteURL returns the published URL of a file in the site.
+func (s *site) absoluteURL(output string) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + (&url.URL{Path: output}).String()
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// writeSitemap lists every page in sitemap.xml, with its date as lastmod.
+func (s *site) writeSitemap() error {
+	set := sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, page := range s.Pages {
+		entry := sitemapURL{Loc: s.absoluteURL(page.Output)}
+		if !page.Meta.Date.IsZero() {
+			entry.LastMod = page.Meta.Date.Format("2006-01-02")
+		}
+		set.URLs = append(set.URLs, entry)
+	}
+	if err := writeXML(filepath.Join(s.OutputDir, "sitemap.xml"), set); err != nil {
+		return err
+	}
+	s.written["sitemap.xml"] = true
+	return nil
+}
+
+// feedPages returns the dated pages, newest first, cut to the configured
+// limit.
+func (s *site) feedPages() []*sitePage {
+	var pages []*sitePage
+	for _, page := range s.Pages {
+		if !page.Meta.Date.IsZero() {
+			pages = append(pages, page)
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].Meta.Date.After(pages[j].Meta.Date.Time)
+	})
+
+	limit := defaultFeedLimit
+	if s.Feed.Limit != nil {
+		limit = *s.Feed.Limit
+	}
+	if limit > 0 && len(pages) > limit {
+		pages = pages[:limit]
+	}
+	return pages
+}
+
+// feedFiles returns the feed files to write for the configured format.
+func (s *site) feedFiles() []string {
+	switch strings.ToLower(s.Feed.Format) {
+	case "", "atom":
+		return []string{"feed.xml"}
+	case "rss":
+		return []string{"rss.xml"}
+	case "both":
+		return []string{"feed.xml", "rss.xml"}
+	}
+	log.Printf("Warning: unknown feed format %q, writing Atom", s.Feed.Format)
+	return []string{"feed.xml"}
+}
+
+// feedLinks returns the <link rel="alternate"> tags for a page's head.
+func (s *site) feedLinks(page *sitePage) string {
+	if !s.hasFeed {
+		return ""
+	}
+	var links []string
+	for _, file := range s.feedFiles() {
+		kind := "application/atom+xml"
+		if file == "rss.xml" {
+			kind = "application/rss+xml"
+		}
+		links = append(links, fmt.Sprintf(`<link rel="alternate" type="%s" title="%s" href="%s">`, kind, template.HTMLEscapeString(s.feedTitle()), s.link(page, file)))
+	}
+	return strings.Join(links, "\n    ")
+}
+
+func (s *site) feedTitle() string {
+	if s.Feed.Title != "" {
+		return s.Feed.Title
+	}
+	return s.Title
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Base string `xml:"xml:base,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Content atomContent `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name  `xml:"rss"`
+	Version string    `xml:"version,attr"`
+	Title   string    `xml:"channel>title"`
+	Link    string    `xml:"channel>link"`
+	Desc    string    `xml:"channel>description"`
+	Items   []rssItem `xml:"channel>item"`
+}
+
+// writeFeeds writes the Atom and/or RSS feed of dated pages, with each
+// page's rendered body as the entry content.
+func (s *site) writeFeeds() error {
+	pages := s.feedPages()
+	home := s.absoluteURL("")
+	author := s.Feed.Author
+	if author == "" {
+		author = s.feedTitle()
+	}
+
+	for _, file := range s.feedFiles() {
+		var feed interface{}
+		if file == "feed.xml" {
+			atom := atomFeed{
+				XMLNS:  "http://www.w3.org/2005/Atom",
+				Title:  s.feedTitle(),
+				ID:     home,
+				Links:  []atomLink{{Href: home}, {Href: s.absoluteURL(file), Rel: "self", Type: "application/atom+xml"}},
+				Author: author,
+			}
+			for _, page := range pages {
+				link := s.absoluteURL(page.Output)
+				atom.Entries = append(atom.Entries, atomEntry{
+					Title:   page.Title,
+					ID:      link,
+					Link:    atomLink{Href: link},
+					Updated: page.Meta.Date.Format(time.RFC3339),
+					Content: atomContent{Type: "html", Base: link, Body: page.Body},
+				})
+			}
+			if len(pages) > 0 {
+				atom.Updated = pages[0].Meta.Date.Format(time.RFC3339)
+			} else {
+				atom.Updated = time.Now().UTC().Format(time.RFC3339)
+			}
+			feed = atom
+		} else {
+			rss := rssFeed{Version: "2.0", Title: s.feedTitle(), Link: home, Desc: s.feedTitle()}
+			for _, page := range pages {
+				link := s.absoluteURL(page.Output)
+				rss.Items = append(rss.Items, rssItem{
+					Title:       page.Title,
+					Link:        link,
+					GUID:        link,
+					PubDate:     page.Meta.Date.Format(time.RFC1123Z),
+					Description: page.Body,
+				})
+			}
+			feed = rss
+		}
+		if err := writeXML(filepath.Join(s.OutputDir, file), feed); err != nil {
+			return err
+		}
+		s.written[file] = true
+	}
+	return nil
+}
+
+func writeXML(file string, v interface{}) error {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
+
+// writeAliases writes a redirect page at each old URL listed in a page's
+// aliases. An alias ending in "/" or without an extension becomes that
+// directory's index.html. Aliases never replace another file of the site.
+func (s *site) writeAliases() error {
+	if dir, err := filepath.Abs(s.OutputDir); err == nil {
+		for _, file := range assets.files() {
+			if rel, err := filepath.Rel(dir, file); err == nil {
+				s.written[filepath.ToSlash(rel)] = true
+			}
+		}
+	}
+
+	for _, page := range s.Pages {
+		for _, alias := range page.Meta.Aliases {
+			file := strings.TrimPrefix(path.Clean("/"+alias), "/")
+			if strings.HasSuffix(alias, "/") || path.Ext(file) == "" {
+				file = path.Join(file, "index.html")
+			}
+			if s.written[file] {
+				log.Printf("Warning: alias %s of %s would replace %s, skipped", alias, page.Source, file)
+				continue
+			}
+			stub := &sitePage{Output: file}
+			output := filepath.Join(s.OutputDir, filepath.FromSlash(file))
+			if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(output, []byte(redirectPage(s.link(stub, page.Output), page.Title)), 0644); err != nil {
+				return err
+			}
+			s.written[file] = true
+		}
+	}
+	return nil
+}
+
+func redirectPage(target, title string) string {
+	target = template.HTMLEscapeString(target)
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>%s</title>
+    <meta http-equiv="refresh" content="0; url=%s">
+    <link rel="canonical" href="%s">
+</head>
+<body>
+    <p>This page has moved to <a href="%s">%s</a>.</p>
+</body>
+</html>
+`, template.HTMLEscapeString(title), target, target, target, template.HTMLEscapeString(title))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// renderFlags registers the conversion flags shared by the converter, the
+// UI and the build command, and applies them to options.
+type renderFlags struct {
+	extensionSpec   string
+	configFile      string
+	policyFile      string
+	diagramCommands diagramCommandFlags
+
+	// config is the config file loaded by apply, if any.
+	config Config
+}
+
+func (f *renderFlags) register(fs *flag.FlagSet) {
+	f.diagramCommands = diagramCommandFlags{}
+
+	fs.StringVar(&f.extensionSpec, "extensions", "", "Comma-separated extensions to enable or disable, e.g. \"-subscript\" or \"none,footnotes\" (available: "+strings.Join(extensionList(), ", ")+")")
+	fs.BoolVar(&options.Diagrams, "diagrams", options.Diagrams, "Render mermaid, dot and plantuml fences to inline SVG with locally installed tools")
+	fs.Var(f.diagramCommands, "diagram-cmd", "Diagram command as lang=command, e.g. \"dot=dot -Tsvg\"; {input} and {output} stand for temp files (repeatable)")
+	fs.DurationVar(&options.DiagramTimeout, "diagram-timeout", options.DiagramTimeout, "Maximum time to wait for a diagram tool")
+	fs.BoolVar(&options.CopyButtons, "copy-buttons", options.CopyButtons, "Add copy-to-clipboard buttons to code blocks")
+	fs.IntVar(&options.CollapseCode, "collapse-code", options.CollapseCode, "Collapse code blocks longer than this many lines behind an expand toggle (0 disables)")
+	fs.BoolVar(&options.DetectLanguage, "detect-language", options.DetectLanguage, "Guess the language of code blocks without one from their content")
+	fs.StringVar(&f.configFile, "config", "", "Config file (default: "+configFileName+" in the input's directory or a parent)")
+	fs.BoolVar(&options.Safe, "safe", options.Safe, "Sanitize the rendered HTML with an allowlist of tags, attributes and URL schemes (default in --ui)")
+	fs.StringVar(&f.policyFile, "safe-policy", "", "YAML allowlist policy file for --safe (implies --safe)")
+	fs.BoolVar(&options.EmbedImages, "embed-images", options.EmbedImages, "Inline local images as data URIs so the HTML file is self-contained")
+	fs.Int64Var(&options.EmbedMaxSize, "embed-max-size", options.EmbedMaxSize, "Largest image in bytes to inline with --embed-images; larger ones are copied to the assets folder (0 for no limit)")
+	fs.BoolVar(&options.CopyAssets, "copy-assets", options.CopyAssets, "Copy local images and linked non-Markdown files into the assets folder and rewrite their URLs")
+	fs.BoolVar(&options.HashAssets, "hash-assets", options.HashAssets, "Give copied assets content-hashed file names")
+	fs.StringVar(&options.AssetsDir, "assets-dir", options.AssetsDir, "Folder next to the output file for copied assets and images too large to inline")
+}
+
+// apply loads the config file, found from dir upwards unless --config was
+// given, and the sanitize policy, then sets up extensions and diagram
+// tools. It returns the flags set explicitly on the command line.
+func (f *renderFlags) apply(fs *flag.FlagSet, dir string) map[string]bool {
+	setFlags := map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) { setFlags[fl.Name] = true })
+
+	configFile := f.configFile
+	if configFile == "" {
+		configFile = findConfig(dir)
+	}
+	policyFile := f.policyFile
+	if configFile != "" {
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			log.Fatalf("Error loading config: %v", err)
+		}
+		applyConfig(cfg, setFlags)
+		f.config = cfg
+		if policyFile == "" && cfg.SafePolicy != "" {
+			policyFile = cfg.SafePolicy
+			if !filepath.IsAbs(policyFile) {
+				policyFile = filepath.Join(filepath.Dir(configFile), policyFile)
+			}
+		}
+	}
+
+	if policyFile != "" {
+		policy, err := loadSanitizePolicy(policyFile)
+		if err != nil {
+			log.Fatalf("Error loading sanitize policy: %v", err)
+		}
+		options.SanitizePolicy = policy
+		if !setFlags["safe"] {
+			options.Safe = true
+		}
+	}
+
+	exts, err := parseExtensions(f.extensionSpec, options.Extensions)
+	if err != nil {
+		log.Fatalf("Error parsing --extensions: %v", err)
+	}
+	options.Extensions = exts
+	setupDiagramRenderers(f.diagramCommands)
+	return setFlags
+}
@@ -0,0 +1,657 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// formatStyle is the canonical style mdreader fmt rewrites documents in.
+type formatStyle struct {
+	width  int  // paragraphs are reflowed to this width; 0 keeps their lines
+	bullet byte // unordered list marker
+}
+
+// newFormatStyle reads the style from the config file. List bullets
+// follow the ul-style lint rule when it names a marker, so formatted
+// documents pass the linter.
+func newFormatStyle(cfg Config, lint lintSettings) formatStyle {
+	style := formatStyle{width: cfg.Format.Width, bullet: '-'}
+	switch lint["ul-style"].Style {
+	case "asterisk":
+		style.bullet = '*'
+	case "plus":
+		style.bullet = '+'
+	}
+	return style
+}
+
+// formatEdit replaces lines start to end of a document with lines. An
+// edit with start equal to end inserts lines.
+type formatEdit struct {
+	start, end int
+	lines      []string
+}
+
+// formatPass finds the edits of one formatting step. Passes see the
+// document as left by the previous ones.
+type formatPass func(d *lintDocument, style formatStyle) []formatEdit
+
+var formatPasses = []formatPass{
+	formatHeadings,
+	formatListMarkers,
+	formatEmphasis,
+	formatTables,
+	formatParagraphs,
+	formatSpacing,
+}
+
+var (
+	formatATXPattern           = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?[ \t]*$`)
+	formatClosingHashesPattern = regexp.MustCompile(`(?:^|[ \t]+)#+$`)
+	formatStrongPattern        = regexp.MustCompile(`(?:^|[^\w\\_])(__)[^\s_](?:[^_]*[^\s_])?(__)(?:$|[^\w_])`)
+	formatEmphasisPattern      = regexp.MustCompile(`(?:^|[^\w\\_])(_)[^\s_](?:[^_]*[^\s_])?(_)(?:$|[^\w_])`)
+	// formatLiteralPattern matches link destinations, HTML tags, URLs and
+	// math, where underscores are not emphasis.
+	formatLiteralPattern      = regexp.MustCompile(`\]\([^)]*\)|<[^>]*>|https?://\S+|\$[^$]*\$`)
+	formatDelimiterRowPattern = regexp.MustCompile(`^\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?$`)
+	// formatBlockStartPattern matches words that would start a block of
+	// their own at the beginning of a line, which reflowing must avoid.
+	formatBlockStartPattern = regexp.MustCompile("^(?:[#>|:<=]|[-+*]+$|\\d+[.)]$|```|~~~|\\$\\$|\\[\\^|\\*\\[|!include|\\{\\{<|[-*_]{3}|\\[[^\\]]*\\]:)")
+)
+
+// runFmt implements "mdreader fmt": it rewrites the Markdown files under
+// the given paths in the canonical style, or with --check lists those that
+// are not and exits with 1.
+func runFmt(args []string) {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	var check bool
+	var width int
+	var render renderFlags
+	fs.BoolVar(&check, "check", false, "List files that are not formatted and exit with 1 instead of rewriting them")
+	fs.IntVar(&width, "width", 0, "Reflow paragraphs to this width; 0 keeps their line breaks")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: mdreader fmt <docs/ | file.md>... [options]")
+		fs.PrintDefaults()
+	}
+	render.register(fs)
+
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	setFlags := render.apply(fs, positional[0])
+	lint, err := newLintSettings(render.config.Lint)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	style := newFormatStyle(render.config, lint)
+	if setFlags["width"] {
+		style.width = width
+	}
+	if style.width < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --width must not be negative")
+		os.Exit(2)
+	}
+
+	files, unformatted := 0, 0
+	for _, arg := range positional {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		inputs := []string{arg}
+		if info.IsDir() {
+			inputs = markdownFiles(arg)
+		}
+		for _, file := range inputs {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(2)
+			}
+			setDocumentOptions(file, "")
+			formatted := formatMarkdown(source, style)
+			files++
+			if string(formatted) == string(source) {
+				continue
+			}
+			unformatted++
+			if check {
+				fmt.Println(file)
+				continue
+			}
+			if err := writeFixed(file, formatted); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(2)
+			}
+			fmt.Printf("Formatted %s\n", file)
+		}
+	}
+
+	if check {
+		fmt.Fprintf(os.Stderr, "%d of %d files need formatting\n", unformatted, files)
+		if unformatted > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// formatter applies the passes to one document, keeping only edits that
+// leave its rendered HTML as it was.
+type formatter struct {
+	newline string
+	want    string // normalized rendering of the original
+}
+
+// formatMarkdown rewrites source in style. The options must point at the
+// document, as for rendering it.
+func formatMarkdown(source []byte, style formatStyle) []byte {
+	f := &formatter{newline: "\n"}
+	if strings.Contains(string(source), "\r\n") {
+		f.newline = "\r\n"
+	}
+	f.want = renderedForComparison(source)
+
+	current := source
+	for _, pass := range formatPasses {
+		d := newLintDocument("", current)
+		edits := pass(d, style)
+		if len(edits) == 0 {
+			continue
+		}
+		sort.SliceStable(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+		current = f.apply(d, f.settle(d, nil, edits))
+	}
+	current = f.apply(newLintDocument("", current), nil)
+	if f.renders(current) {
+		return current
+	}
+	return source
+}
+
+// settle returns kept plus those of edits that can be applied with it
+// without changing the rendering. Edits are tried together first and the
+// set is halved until the ones at fault are found.
+func (f *formatter) settle(d *lintDocument, kept, edits []formatEdit) []formatEdit {
+	candidate := append(append([]formatEdit(nil), kept...), edits...)
+	if f.renders(f.apply(d, candidate)) {
+		return candidate
+	}
+	if len(edits) == 1 {
+		return kept
+	}
+	half := len(edits) / 2
+	kept = f.settle(d, kept, edits[:half])
+	return f.settle(d, kept, edits[half:])
+}
+
+// renders reports whether source renders like the original.
+func (f *formatter) renders(source []byte) bool {
+	return renderedForComparison(source) == f.want
+}
+
+// apply returns the document with edits applied, ending in exactly one
+// newline.
+func (f *formatter) apply(d *lintDocument, edits []formatEdit) []byte {
+	sorted := append([]formatEdit(nil), edits...)
+	// From the end backwards, so earlier line numbers stay valid; at the
+	// same line, the replacement goes before an insertion there.
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].start != sorted[j].start {
+			return sorted[i].start > sorted[j].start
+		}
+		return sorted[i].end > sorted[j].end
+	})
+	lines := append([]string(nil), d.lines...)
+	for _, e := range sorted {
+		tail := append([]string(nil), lines[e.end:]...)
+		lines = append(append(lines[:e.start], e.lines...), tail...)
+	}
+	for len(lines) > d.first && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, f.newline) + f.newline)
+}
+
+var (
+	preBlockPattern     = regexp.MustCompile(`(?s)<pre[ >].*?</pre>`)
+	taskLineAttrPattern = regexp.MustCompile(` data-task-line="\d+"`)
+)
+
+// renderedForComparison renders source and normalizes the result so that
+// only differences a reader could see remain: whitespace outside <pre>
+// is collapsed, and the source lines recorded for the editor's task
+// checkboxes are dropped.
+func renderedForComparison(source []byte) string {
+	// Diagram fences compare by their source; there is no need to run the
+	// diagram tools.
+	diagrams := options.Diagrams
+	options.Diagrams = false
+	defer func() { options.Diagrams = diagrams }()
+
+	_, markdown := splitFrontMatter(source)
+	html := taskLineAttrPattern.ReplaceAllString(convertMarkdownToHTMLBody(markdown), "")
+
+	var b strings.Builder
+	last := 0
+	for _, m := range preBlockPattern.FindAllStringIndex(html, -1) {
+		b.WriteString(strings.Join(strings.Fields(html[last:m[0]]), " "))
+		b.WriteString(html[m[0]:m[1]])
+		last = m[1]
+	}
+	b.WriteString(strings.Join(strings.Fields(html[last:]), " "))
+	return b.String()
+}
+
+// formatHeadings turns setext headings into ATX headings and writes ATX
+// headings with one space after the hashes and no closing hashes.
+func formatHeadings(d *lintDocument, style formatStyle) []formatEdit {
+	var edits []formatEdit
+	for _, i := range d.headings {
+		line := d.lines[i]
+		if lintATXHeadingPattern.MatchString(line) {
+			if m := formatATXPattern.FindStringSubmatch(line); m != nil {
+				text := formatClosingHashesPattern.ReplaceAllString(m[2], "")
+				if heading := strings.TrimSpace(m[1] + " " + text); heading != line {
+					edits = append(edits, formatEdit{start: i, end: i + 1, lines: []string{heading}})
+				}
+			}
+			continue
+		}
+		// A setext heading of more than one line is left alone.
+		text := strings.TrimSpace(line)
+		if i+1 >= len(d.lines) || strings.HasPrefix(text, ">") ||
+			(i > d.first && strings.TrimSpace(d.lines[i-1]) != "" && !d.code[i-1]) {
+			continue
+		}
+		level := "##"
+		if strings.TrimSpace(d.lines[i+1])[0] == '=' {
+			level = "#"
+		}
+		edits = append(edits, formatEdit{start: i, end: i + 2, lines: []string{level + " " + text}})
+	}
+	return edits
+}
+
+// formatListMarkers gives every unordered list item the style's bullet,
+// using the fixes of the ul-style lint rule.
+func formatListMarkers(d *lintDocument, style formatStyle) []formatEdit {
+	config := LintRuleConfig{Style: map[byte]string{'-': "dash", '*': "asterisk", '+': "plus"}[style.bullet]}
+	return lintFixEdits(d, "ul-style", config)
+}
+
+// lintFixEdits runs a lint rule over the whole document, regardless of
+// disable comments, and turns its fixes into edits.
+func lintFixEdits(d *lintDocument, name string, config LintRuleConfig) []formatEdit {
+	for i := range d.disabled {
+		d.disabled[i] = nil
+	}
+	d.rule, d.config, d.problems = findLintRule(name), config, nil
+	d.rule.check(d)
+
+	fixed := map[int]string{}
+	var order []int
+	for _, p := range d.problems {
+		for _, e := range p.edits {
+			if _, ok := fixed[e.line]; !ok {
+				fixed[e.line] = d.lines[e.line]
+				order = append(order, e.line)
+			}
+		}
+	}
+	var edits []formatEdit
+	for _, line := range order {
+		var lineEdits []lintEdit
+		for _, p := range d.problems {
+			for _, e := range p.edits {
+				if e.line == line {
+					lineEdits = append(lineEdits, e)
+				}
+			}
+		}
+		sort.Slice(lineEdits, func(i, j int) bool { return lineEdits[i].start > lineEdits[j].start })
+		text := fixed[line]
+		for _, e := range lineEdits {
+			text = text[:e.start] + e.text + text[e.end:]
+		}
+		edits = append(edits, formatEdit{start: line, end: line + 1, lines: []string{text}})
+	}
+	return edits
+}
+
+// formatEmphasis writes emphasis as *text* and strong emphasis as
+// **text**, instead of with underscores.
+func formatEmphasis(d *lintDocument, style formatStyle) []formatEdit {
+	var edits []formatEdit
+	for i := d.first; i < len(d.lines); i++ {
+		if d.code[i] {
+			continue
+		}
+		line := []byte(d.lines[i])
+		masked := []byte(d.masked[i])
+		for _, m := range formatLiteralPattern.FindAllIndex(masked, -1) {
+			copy(masked[m[0]:m[1]], strings.Repeat(" ", m[1]-m[0]))
+		}
+		for _, p := range []struct {
+			pattern *regexp.Regexp
+			marker  string
+		}{{formatStrongPattern, "**"}, {formatEmphasisPattern, "*"}} {
+			// Replaced delimiters no longer match, so each search finds
+			// the next pair.
+			for m := p.pattern.FindSubmatchIndex(masked); m != nil; m = p.pattern.FindSubmatchIndex(masked) {
+				for _, at := range []int{m[2], m[4]} {
+					copy(line[at:], p.marker)
+					copy(masked[at:], p.marker)
+				}
+			}
+		}
+		if string(line) != d.lines[i] {
+			edits = append(edits, formatEdit{start: i, end: i + 1, lines: []string{string(line)}})
+		}
+	}
+	return edits
+}
+
+// formatTables pads the cells of pipe tables so the pipes line up, with
+// the delimiter row as wide as each column and its alignment colons kept.
+func formatTables(d *lintDocument, style formatStyle) []formatEdit {
+	var edits []formatEdit
+	for i := d.first; i+1 < len(d.lines); i++ {
+		if d.code[i] || d.code[i+1] || !strings.Contains(d.masked[i], "|") || indentOf(d.lines[i]) > 3 ||
+			!strings.Contains(d.lines[i+1], "|") || !formatDelimiterRowPattern.MatchString(strings.TrimSpace(d.lines[i+1])) {
+			continue
+		}
+		end := i + 2
+		for end < len(d.lines) && !d.code[end] && strings.Contains(d.masked[end], "|") {
+			end++
+		}
+		var rows [][]string
+		for j := i; j < end; j++ {
+			rows = append(rows, tableCells(d.lines[j], d.masked[j]))
+		}
+		if len(rows[0]) != len(rows[1]) {
+			i = end - 1
+			continue
+		}
+
+		widths := make([]int, len(rows[1]))
+		for r, row := range rows {
+			for c, cell := range row {
+				if c >= len(widths) {
+					widths = append(widths, 3)
+				}
+				if n := utf8.RuneCountInString(cell); r != 1 && n > widths[c] {
+					widths[c] = n
+				}
+			}
+		}
+		for c := range widths {
+			if widths[c] < 3 {
+				widths[c] = 3
+			}
+		}
+
+		var table []string
+		for r, row := range rows {
+			cells := make([]string, len(row))
+			for c, cell := range row {
+				align := ""
+				if c < len(rows[1]) {
+					align = rows[1][c]
+				}
+				if r == 1 {
+					cells[c] = delimiterCell(cell, widths[c])
+				} else {
+					cells[c] = padCell(cell, widths[c], align)
+				}
+			}
+			table = append(table, "| "+strings.Join(cells, " | ")+" |")
+		}
+		if strings.Join(table, "\n") != strings.Join(d.lines[i:end], "\n") {
+			edits = append(edits, formatEdit{start: i, end: end, lines: table})
+		}
+		i = end - 1
+	}
+	return edits
+}
+
+// tableCells splits a table row into its trimmed cells, at pipes that are
+// not escaped or inside code.
+func tableCells(line, masked string) []string {
+	trimmed := strings.TrimSpace(masked)
+	start := len(masked) - len(strings.TrimLeft(masked, " \t"))
+	end := start + len(trimmed)
+	if strings.HasPrefix(trimmed, "|") {
+		start++
+	}
+	if strings.HasSuffix(trimmed, "|") && !strings.HasSuffix(trimmed, "\\|") && end > start {
+		end--
+	}
+	var cells []string
+	cell := start
+	for i := start; i < end; i++ {
+		if masked[i] == '|' && (i == 0 || masked[i-1] != '\\') {
+			cells = append(cells, strings.TrimSpace(line[cell:i]))
+			cell = i + 1
+		}
+	}
+	return append(cells, strings.TrimSpace(line[cell:end]))
+}
+
+// delimiterCell writes a delimiter row cell of width, keeping the
+// alignment colons of cell.
+func delimiterCell(cell string, width int) string {
+	left, right := strings.HasPrefix(cell, ":"), strings.HasSuffix(cell, ":")
+	dashes := width
+	if left {
+		dashes--
+	}
+	if right {
+		dashes--
+	}
+	s := strings.Repeat("-", dashes)
+	if left {
+		s = ":" + s
+	}
+	if right {
+		s += ":"
+	}
+	return s
+}
+
+// padCell pads cell to width on the side its column's alignment calls for.
+func padCell(cell string, width int, align string) string {
+	pad := width - utf8.RuneCountInString(cell)
+	switch {
+	case pad <= 0:
+		return cell
+	case strings.HasPrefix(align, ":") && strings.HasSuffix(align, ":"):
+		return strings.Repeat(" ", pad/2) + cell + strings.Repeat(" ", pad-pad/2)
+	case strings.HasSuffix(align, ":"):
+		return strings.Repeat(" ", pad) + cell
+	}
+	return cell + strings.Repeat(" ", pad)
+}
+
+// formatParagraphs reflows plain paragraphs to the style's width. Only
+// paragraphs starting at the left margin after a blank line or heading
+// are touched; those in lists and quotes, and with line breaks, keep their
+// lines.
+func formatParagraphs(d *lintDocument, style formatStyle) []formatEdit {
+	if style.width <= 0 {
+		return nil
+	}
+	heading := map[int]bool{}
+	for _, i := range d.headings {
+		heading[i] = true
+	}
+	var edits []formatEdit
+	for i := d.first; i < len(d.lines); i++ {
+		if i > d.first && strings.TrimSpace(d.lines[i-1]) != "" && !heading[i-1] {
+			continue
+		}
+		end := i
+		for end < len(d.lines) && isPlainParagraphLine(d, end, heading) {
+			end++
+		}
+		if end == i {
+			continue
+		}
+		// A setext underline makes the paragraph a heading.
+		if end < len(d.lines) && strings.TrimSpace(d.lines[end]) != "" {
+			i = end
+			continue
+		}
+		var words []string
+		for _, line := range d.lines[i:end] {
+			words = append(words, splitWords(line)...)
+		}
+		lines := wrapWords(words, style.width)
+		if strings.Join(lines, "\n") != strings.Join(d.lines[i:end], "\n") {
+			edits = append(edits, formatEdit{start: i, end: end, lines: lines})
+		}
+		i = end
+	}
+	return edits
+}
+
+// isPlainParagraphLine reports whether a line is ordinary paragraph text
+// that can be joined with its neighbours.
+func isPlainParagraphLine(d *lintDocument, i int, heading map[int]bool) bool {
+	line := d.lines[i]
+	switch {
+	case d.code[i], heading[i], strings.TrimSpace(line) == "", indentOf(line) > 0,
+		strings.HasSuffix(line, "  "), strings.HasSuffix(line, "\\"),
+		strings.Contains(d.masked[i], "|"), strings.Contains(line, "<!--"),
+		lintBulletPattern.MatchString(line), lintOrderedPattern.MatchString(line),
+		lintThematicBreakPattern.MatchString(line), referenceDefinitionPattern.MatchString(line),
+		formatBlockStartPattern.MatchString(line):
+		return false
+	}
+	return true
+}
+
+// splitWords splits a line at spaces outside code spans.
+func splitWords(line string) []string {
+	var words []string
+	word := ""
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t':
+			if word != "" {
+				words = append(words, word)
+				word = ""
+			}
+			i++
+		case c == '`':
+			n := 0
+			for i+n < len(line) && line[i+n] == '`' {
+				n++
+			}
+			close := strings.Index(line[i+n:], line[i:i+n])
+			if close < 0 {
+				word += line[i : i+n]
+				i += n
+				continue
+			}
+			word += line[i : i+n+close+n]
+			i += n + close + n
+		default:
+			word += string(c)
+			i++
+		}
+	}
+	if word != "" {
+		words = append(words, word)
+	}
+	return words
+}
+
+// wrapWords fills lines of at most width characters with words. A line
+// is allowed to run over rather than start with a word that would begin a
+// heading, list or other block.
+func wrapWords(words []string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range words {
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width || formatBlockStartPattern.MatchString(word):
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// formatSpacing removes trailing whitespace, except two spaces making a
+// line break, folds runs of blank lines into one and puts a blank line
+// before and after each heading.
+func formatSpacing(d *lintDocument, style formatStyle) []formatEdit {
+	blank := func(i int) bool { return strings.TrimSpace(d.lines[i]) == "" }
+	var edits []formatEdit
+	for _, e := range lintFixEdits(d, "no-trailing-spaces", LintRuleConfig{}) {
+		if !blank(e.start) {
+			edits = append(edits, e)
+		}
+	}
+
+	for i := d.first; i < len(d.lines); i++ {
+		if d.code[i] || !blank(i) {
+			continue
+		}
+		end := i + 1
+		for end < len(d.lines) && !d.code[end] && blank(end) {
+			end++
+		}
+		if end-i > 1 || d.lines[i] != "" {
+			edits = append(edits, formatEdit{start: i, end: end, lines: []string{""}})
+		}
+		i = end - 1
+	}
+
+	inserted := map[int]bool{}
+	insertBlank := func(at int) {
+		if !inserted[at] {
+			inserted[at] = true
+			edits = append(edits, formatEdit{start: at, end: at, lines: []string{""}})
+		}
+	}
+	for _, h := range d.headings {
+		if !lintATXHeadingPattern.MatchString(d.lines[h]) || strings.HasPrefix(strings.TrimSpace(d.lines[h]), ">") {
+			continue
+		}
+		if h > d.first && !blank(h-1) {
+			insertBlank(h)
+		}
+		if h+1 < len(d.lines) && !blank(h+1) {
+			insertBlank(h + 1)
+		}
+	}
+	return edits
+}
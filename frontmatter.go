@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FrontMatter is the YAML block between "---" lines at the top of a
+// document. Pointer fields distinguish "not set" from a zero value so they
+// can override command-line defaults.
+type FrontMatter struct {
+	Title string `yaml:"title"`
+
+	// Weight orders pages in a site's navigation; lower comes first.
+	Weight int `yaml:"weight"`
+
+	// Date puts a page in the site's feed, and Aliases lists old URLs,
+	// relative to the site root, that redirect to the page.
+	Date    Date       `yaml:"date"`
+	Aliases StringList `yaml:"aliases"`
+
+	// Tags and Categories group pages on the site's taxonomy pages.
+	Tags       StringList `yaml:"tags"`
+	Categories StringList `yaml:"categories"`
+
+	CopyButtons  *bool `yaml:"copy_buttons"`
+	CollapseCode *int  `yaml:"collapse_code"`
+}
+
+// splitFrontMatter parses a leading front matter block and returns it with
+// the document body. The block is replaced by blank lines so line numbers
+// in the body still match the file.
+func splitFrontMatter(markdown []byte) (FrontMatter, []byte) {
+	var meta FrontMatter
+	if !bytes.HasPrefix(markdown, []byte("---\n")) && !bytes.HasPrefix(markdown, []byte("---\r\n")) {
+		return meta, markdown
+	}
+
+	start := bytes.IndexByte(markdown, '\n') + 1
+	end := -1
+	for pos := start; pos < len(markdown); {
+		next := bytes.IndexByte(markdown[pos:], '\n')
+		line := markdown[pos:]
+		if next >= 0 {
+			line = markdown[pos : pos+next]
+		}
+		if trimmed := bytes.TrimRight(line, " \t\r"); bytes.Equal(trimmed, []byte("---")) || bytes.Equal(trimmed, []byte("...")) {
+			end = pos
+			break
+		}
+		if next < 0 {
+			break
+		}
+		pos += next + 1
+	}
+	if end < 0 {
+		return meta, markdown
+	}
+
+	if err := yaml.Unmarshal(markdown[start:end], &meta); err != nil {
+		log.Printf("Warning: ignoring invalid front matter: %v", err)
+		return FrontMatter{}, markdown
+	}
+
+	closeEnd := len(markdown)
+	if i := bytes.IndexByte(markdown[end:], '\n'); i >= 0 {
+		closeEnd = end + i + 1
+	}
+	blank := bytes.Repeat([]byte("\n"), bytes.Count(markdown[:closeEnd], []byte("\n")))
+	return meta, append(blank, markdown[closeEnd:]...)
+}
+
+// Date is a front matter date. It accepts a plain date, a date and time
+// with or without seconds, or RFC 3339, quoted or not.
+type Date struct {
+	time.Time
+}
+
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+func (d *Date) UnmarshalYAML(node *yaml.Node) error {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, node.Value); err == nil {
+			d.Time = t
+			return nil
+		}
+	}
+	return fmt.Errorf("line %d: cannot parse date %q", node.Line, node.Value)
+}
+
+// StringList is a list of strings that may also be written as a single
+// string, so "tags: go" works as well as "tags: [go, cli]".
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = StringList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
@@ -6,6 +6,8 @@ require (
 	github.com/alecthomas/chroma/v2 v2.20.0
 	github.com/gorilla/websocket v1.5.3
 	github.com/russross/blackfriday/v2 v2.1.0
+	golang.org/x/net v0.47.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require github.com/dlclark/regexp2 v1.11.5 // indirect
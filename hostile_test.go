@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	// activeTagPattern matches an element that can run script or load a
+	// resource. Escaped markup shows up as &lt;script and never matches.
+	activeTagPattern = regexp.MustCompile(`(?i)<(?:script|img|iframe|svg)[\s/>]`)
+	// outputTagPattern matches the real tags of the output; a > inside an
+	// attribute value is always escaped.
+	outputTagPattern   = regexp.MustCompile(`<[a-zA-Z][^>]*>`)
+	quotedValuePattern = regexp.MustCompile(`"[^"]*"|'[^']*'`)
+	eventHandlerAttr   = regexp.MustCompile(`(?i)\son[a-z]+\s*=`)
+)
+
+type hostileBlock struct {
+	name   string
+	source string
+}
+
+// hostileBlocks returns each "## " section of hostile-code-test.md, and
+// the whole file as one more block.
+func hostileBlocks(t *testing.T) []hostileBlock {
+	t.Helper()
+	source, err := os.ReadFile("hostile-code-test.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []hostileBlock
+	for _, section := range strings.Split(string(source), "\n## ")[1:] {
+		name, body, _ := strings.Cut(section, "\n")
+		blocks = append(blocks, hostileBlock{name, body})
+	}
+	if len(blocks) == 0 {
+		t.Fatal("hostile-code-test.md has no sections")
+	}
+	return append(blocks, hostileBlock{"whole file", string(source)})
+}
+
+// checkInert fails the test if html carries an active element or an
+// event handler attribute.
+func checkInert(t *testing.T, name, html string) {
+	t.Helper()
+	if m := activeTagPattern.FindString(html); m != "" {
+		t.Errorf("%s: output contains %q", name, m)
+	}
+	for _, tag := range outputTagPattern.FindAllString(html, -1) {
+		if eventHandlerAttr.MatchString(quotedValuePattern.ReplaceAllString(tag, `""`)) {
+			t.Errorf("%s: output contains event handler in %s", name, tag)
+		}
+	}
+}
+
+func TestHostileCodeIsEscaped(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.Diagrams = true
+
+	// A diagram tool that fails sends the fence down the fallback path.
+	registerDiagramRenderer("dot", commandDiagramRenderer{Command: "false"})
+	defer func() {
+		diagramRenderersMu.Lock()
+		delete(diagramRenderers, "dot")
+		diagramRenderersMu.Unlock()
+	}()
+
+	for _, block := range hostileBlocks(t) {
+		for _, safe := range []bool{false, true} {
+			options.Safe = safe
+			mode := ""
+			if safe {
+				mode = " (safe)"
+			}
+			checkInert(t, block.name+mode, convertMarkdownToHTML([]byte(block.source)))
+			checkInert(t, block.name+" in the UI"+mode, convertMarkdownToHTMLForUI([]byte(block.source)))
+		}
+	}
+}
+
+func TestPlainCodeBlockIsEscaped(t *testing.T) {
+	for _, block := range hostileBlocks(t) {
+		checkInert(t, block.name, plainCodeBlock(block.source, `"><img src=x onerror=alert('lang')>`))
+	}
+}
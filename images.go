@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// embedImage returns the URL to use for src: a data URI when the file is
+// within the size limit, a path into the assets folder when it is larger,
+// or src itself when it is not a local file or cannot be read.
+func embedImage(src string) string {
+	file, ok := localPath(src)
+	if !ok || !allowedLocalFile(file, src, "embedded") {
+		return src
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		warnOnce(fmt.Sprintf("image %s not embedded: %v", src, err))
+		return src
+	}
+
+	if options.EmbedMaxSize > 0 && int64(len(data)) > options.EmbedMaxSize {
+		return copyLocalURL(src, true)
+	}
+
+	return "data:" + imageMIMEType(file, data) + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+func imageMIMEType(file string, data []byte) string {
+	if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(file))); strings.HasPrefix(t, "image/") {
+		return strings.SplitN(t, ";", 2)[0]
+	}
+	return http.DetectContentType(data)
+}
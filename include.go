@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// maxIncludeDepth bounds how deeply included files may include others.
+const maxIncludeDepth = 8
+
+var (
+	// includeShortcodePattern matches {{< include "file.md" >}} and
+	// {{< snippet "main.go" lines="3-9" >}} on a line of their own.
+	includeShortcodePattern = regexp.MustCompile(`^(\s*)\{\{<\s*(include|snippet)\s+(.*?)\s*>\}\}\s*$`)
+	// includeBangPattern matches the shorter !include file.md form.
+	includeBangPattern = regexp.MustCompile(`^(\s*)!include\s+(.+?)\s*$`)
+
+	// Named regions are marked with "#region name" ... "#endregion", as in
+	// VS Code, or with "[start:name]" ... "[end:name]", in a comment of the
+	// file's language.
+	regionStartPattern = regexp.MustCompile(`#\s*region\b[ \t]*(\S*)|\[start:([^\]]+)\]`)
+	regionEndPattern   = regexp.MustCompile(`#\s*endregion\b[ \t]*(\S*)|\[end:([^\]]+)\]`)
+
+	// markdownURLPattern finds the destination of inline links and images.
+	markdownURLPattern = regexp.MustCompile(`(\]\(\s*)([^)\s]+)`)
+)
+
+// includeDirective is one include or snippet line.
+type includeDirective struct {
+	snippet bool
+	path    string
+	lines   string   // "10-20", "10-" or "10"
+	region  string   // name of a marked region
+	lang    string   // overrides the language guessed from the file name
+	dedent  bool     // strip the indentation common to all lines
+	info    []string // other attributes, passed on to the code fence
+}
+
+// expandIncludes replaces include directives outside code fences with the
+// files they name, relative to baseDir. Markdown files are included as
+// Markdown, expanding their own includes; any other file, or a Markdown
+// file with lines, region or lang, becomes a fenced code block.
+//
+// Includes change line numbers, so expandIncludes also returns the line of
+// markdown each line of the result comes from, 0 for included lines. The
+// map is nil when nothing was included.
+func expandIncludes(markdown []byte, baseDir string) ([]byte, []int) {
+	if !bytes.Contains(markdown, []byte("include")) && !bytes.Contains(markdown, []byte("snippet")) {
+		return markdown, nil
+	}
+	if abs, err := filepath.Abs(baseDir); err == nil {
+		baseDir = abs
+	}
+	inc := &includer{root: includeRoot(baseDir)}
+	if options.Source != "" {
+		// The document itself, so including it is caught as a cycle.
+		if file, err := filepath.Abs(filepath.Join(baseDir, filepath.Base(options.Source))); err == nil {
+			inc.stack = []string{file}
+		}
+	}
+	lines, origins, changed := inc.expand(markdown, baseDir)
+	if !changed {
+		return markdown, nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), origins
+}
+
+// sourceLines maps line numbers of expanded Markdown back to the source.
+func sourceLines(lines, lineMap []int) []int {
+	if lineMap == nil {
+		return lines
+	}
+	mapped := make([]int, len(lines))
+	for i, line := range lines {
+		if line > 0 && line <= len(lineMap) {
+			mapped[i] = lineMap[line-1]
+		}
+	}
+	return mapped
+}
+
+// includeRoot is the directory --safe keeps includes within: the
+// workspace or site source if set, otherwise the document's directory.
+func includeRoot(baseDir string) string {
+	root := wikiRoot(baseDir)
+	if abs, err := filepath.Abs(root); err == nil {
+		return abs
+	}
+	return root
+}
+
+// withinRoot reports whether file, an absolute path, is root or below it.
+func withinRoot(root, file string) bool {
+	rel, err := filepath.Rel(root, file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+type includer struct {
+	root  string
+	stack []string // files being included, outermost first
+}
+
+// expand returns the lines of markdown with its directives replaced, the
+// source line of each, and whether anything was replaced.
+func (inc *includer) expand(markdown []byte, dir string) (lines []string, origins []int, changed bool) {
+	code := newCodeScanner()
+	scanner := bufio.NewScanner(bytes.NewReader(markdown))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(markdown)+1)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		switch {
+		case code.inside(line):
+		default:
+			indent, directive, ok := parseIncludeDirective(line)
+			if !ok {
+				break
+			}
+			included, err := inc.include(directive, dir)
+			if err != nil {
+				where := ""
+				if options.Source != "" {
+					where = options.Source + ": "
+				}
+				warning := fmt.Sprintf("%s%s not included: %v", where, directive.path, err)
+				warnOnce(warning)
+				included = []string{"", fmt.Sprintf(`<div class="include-warning">%s</div>`, template.HTMLEscapeString(warning)), ""}
+			}
+			for _, l := range included {
+				if l != "" {
+					l = indent + l
+				}
+				lines = append(lines, l)
+				origins = append(origins, 0)
+			}
+			changed = true
+			continue
+		}
+		lines = append(lines, line)
+		origins = append(origins, lineNo)
+	}
+	return lines, origins, changed
+}
+
+// parseIncludeDirective recognizes a directive line and returns its
+// indentation, which included lines get as well.
+func parseIncludeDirective(line string) (string, includeDirective, bool) {
+	var d includeDirective
+	var indent, args string
+	if m := includeShortcodePattern.FindStringSubmatch(line); m != nil {
+		indent, d.snippet, args = m[1], m[2] == "snippet", m[3]
+	} else if m := includeBangPattern.FindStringSubmatch(line); m != nil {
+		indent, args = m[1], m[2]
+	} else {
+		return "", d, false
+	}
+
+	fields := splitInfoString(args)
+	if len(fields) == 0 {
+		return "", d, false
+	}
+	d.path = strings.Trim(fields[0], `"'`)
+	d.dedent = true
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		value = strings.Trim(value, `"'`)
+		switch strings.ToLower(key) {
+		case "lines":
+			d.lines = value
+		case "region":
+			d.region = value
+		case "lang":
+			d.lang = value
+		case "dedent":
+			d.dedent = value != "false"
+		default:
+			d.info = append(d.info, field)
+		}
+	}
+	return indent, d, d.path != ""
+}
+
+// include returns the lines a directive stands for.
+func (inc *includer) include(d includeDirective, dir string) ([]string, error) {
+	file := filepath.FromSlash(d.path)
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	if options.Safe && !withinRoot(inc.root, file) {
+		return nil, fmt.Errorf("files outside %s cannot be included with --safe", filepath.Base(inc.root))
+	}
+
+	if d.snippet || !isMarkdownFile(file) || d.lines != "" || d.region != "" || d.lang != "" {
+		return snippetLines(file, d)
+	}
+
+	for i, open := range inc.stack {
+		if open == file {
+			chain := make([]string, 0, len(inc.stack)-i+1)
+			for _, f := range append(inc.stack[i:], file) {
+				chain = append(chain, filepath.Base(f))
+			}
+			return nil, fmt.Errorf("include cycle %s", strings.Join(chain, " → "))
+		}
+	}
+	if len(inc.stack) >= maxIncludeDepth {
+		return nil, fmt.Errorf("includes nested more than %d deep", maxIncludeDepth)
+	}
+
+	source, err := readIncluded(file)
+	if err != nil {
+		return nil, err
+	}
+	_, markdown := splitFrontMatter(source)
+	inc.stack = append(inc.stack, file)
+	lines, _, _ := inc.expand(markdown, filepath.Dir(file))
+	inc.stack = inc.stack[:len(inc.stack)-1]
+
+	// Relative links and images are written from the included file's
+	// directory; point them at the same files from the including one.
+	if rel, err := filepath.Rel(dir, filepath.Dir(file)); err == nil && rel != "." {
+		rebaseLinks(lines, filepath.ToSlash(rel))
+	}
+	return lines, nil
+}
+
+// readIncluded reads an included file. The error is shown in the page, so
+// it does not repeat the file's full path.
+func readIncluded(file string) ([]byte, error) {
+	source, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("file not found")
+	}
+	if err != nil {
+		return nil, errors.Unwrap(err)
+	}
+	return source, nil
+}
+
+// rebaseLinks prefixes the relative destinations of inline links and
+// images outside code fences with prefix.
+func rebaseLinks(lines []string, prefix string) {
+	code := newCodeScanner()
+	for i, line := range lines {
+		if code.inside(line) {
+			continue
+		}
+		lines[i] = markdownURLPattern.ReplaceAllStringFunc(line, func(match string) string {
+			m := markdownURLPattern.FindStringSubmatch(match)
+			u, err := url.Parse(m[2])
+			if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+				return match
+			}
+			u.Path = path.Join(prefix, u.Path)
+			return m[1] + u.String()
+		})
+	}
+}
+
+// snippetLines returns a fenced code block with the requested part of file.
+func snippetLines(file string, d includeDirective) ([]string, error) {
+	source, err := readIncluded(file)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(string(source), "\r\n", "\n"), "\n"), "\n")
+
+	first := 1
+	switch {
+	case d.region != "" && d.lines != "":
+		return nil, fmt.Errorf("use either lines or region, not both")
+	case d.region != "":
+		if lines, first, err = snippetRegion(lines, d.region); err != nil {
+			return nil, err
+		}
+	case d.lines != "":
+		start, end, err := parseSnippetLines(d.lines, len(lines))
+		if err != nil {
+			return nil, err
+		}
+		lines, first = lines[start-1:end], start
+	}
+	if d.dedent {
+		lines = dedentLines(lines)
+	}
+
+	lang := d.lang
+	if lang == "" {
+		if lexer := lexers.Match(filepath.Base(file)); lexer != nil && len(lexer.Config().Aliases) > 0 {
+			lang = lexer.Config().Aliases[0]
+		}
+	}
+	info := append([]string{lang}, d.info...)
+	if first > 1 && wantsLineNumbers(d.info) {
+		info = append(info, "start="+strconv.Itoa(first))
+	}
+
+	fence := "```"
+	for _, line := range lines {
+		for strings.Contains(line, fence) {
+			fence += "`"
+		}
+	}
+	out := append([]string{fence + strings.TrimSpace(strings.Join(info, " "))}, lines...)
+	return append(out, fence), nil
+}
+
+// parseSnippetLines parses "10-20", "10-" or "10" against a file of total
+// lines.
+func parseSnippetLines(spec string, total int) (start, end int, err error) {
+	from, to, isRange := strings.Cut(spec, "-")
+	if start, err = strconv.Atoi(strings.TrimSpace(from)); err != nil {
+		return 0, 0, fmt.Errorf("invalid lines %q", spec)
+	}
+	end = start
+	if isRange {
+		end = total
+		if to = strings.TrimSpace(to); to != "" {
+			if end, err = strconv.Atoi(to); err != nil {
+				return 0, 0, fmt.Errorf("invalid lines %q", spec)
+			}
+		}
+	}
+	if start < 1 || end < start || start > total {
+		return 0, 0, fmt.Errorf("lines %s out of range, the file has %d", spec, total)
+	}
+	if end > total {
+		end = total
+	}
+	return start, end, nil
+}
+
+// snippetRegion returns the lines between the markers of a named region,
+// without the marker lines of regions nested in it, and the line number of
+// its first line. An unnamed "#endregion" closes the innermost "#region".
+func snippetRegion(lines []string, name string) ([]string, int, error) {
+	start := -1
+	for i, line := range lines {
+		if m := regionStartPattern.FindStringSubmatch(line); m != nil && m[1]+m[2] == name {
+			start = i + 1
+			break
+		}
+	}
+	if start < 0 {
+		return nil, 0, fmt.Errorf("no region %q", name)
+	}
+
+	depth := 0
+	for i := start; i < len(lines); i++ {
+		if m := regionEndPattern.FindStringSubmatch(lines[i]); m != nil {
+			if m[1] == name || m[2] == name || (m[0][0] == '#' && m[1] == "" && depth == 0) {
+				var region []string
+				for _, line := range lines[start:i] {
+					if !regionStartPattern.MatchString(line) && !regionEndPattern.MatchString(line) {
+						region = append(region, line)
+					}
+				}
+				return region, start + 1, nil
+			}
+			if m[0][0] == '#' {
+				depth--
+			}
+		} else if m := regionStartPattern.FindStringSubmatch(lines[i]); m != nil && m[0][0] == '#' {
+			depth++
+		}
+	}
+	return nil, 0, fmt.Errorf("region %q is not closed", name)
+}
+
+// dedentLines strips the leading whitespace shared by all non-blank lines.
+func dedentLines(lines []string) []string {
+	prefix := ""
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			prefix, first = indent, false
+			continue
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if prefix == "" {
+		return lines
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = strings.TrimPrefix(line, prefix)
+	}
+	return out
+}
+
+// wantsLineNumbers reports whether fence attributes turn on line numbers
+// without choosing where they start.
+func wantsLineNumbers(info []string) bool {
+	numbered := false
+	for _, field := range info {
+		key, _, _ := strings.Cut(strings.ToLower(field), "=")
+		switch key {
+		case "start", "linenostart", "startline", "startfrom":
+			return false
+		case "linenos", "linenums", "showlinenumbers", "numberlines":
+			numbered = true
+		}
+	}
+	return numbered
+}
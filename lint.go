@@ -0,0 +1,673 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// lintRule is a check run by mdreader lint. Rules are named after their
+// markdownlint counterparts, whose IDs work as aliases.
+type lintRule struct {
+	name  string
+	id    string
+	check func(d *lintDocument)
+}
+
+var lintRules = []lintRule{
+	{"heading-increment", "MD001", lintHeadingIncrement},
+	{"ul-style", "MD004", lintListMarkers},
+	{"no-trailing-spaces", "MD009", lintTrailingSpaces},
+	{"line-length", "MD013", lintLineLength},
+	{"single-h1", "MD025", lintSingleH1},
+	{"no-bare-urls", "MD034", lintBareURLs},
+	{"fenced-code-language", "MD040", lintFenceLanguage},
+}
+
+var (
+	lintATXHeadingPattern    = regexp.MustCompile(`^(?:[ \t]*>)*[ \t]{0,3}#{1,6}(?:[ \t]|$)`)
+	lintSetextPattern        = regexp.MustCompile(`^ {0,3}(?:=+|-+)[ \t]*$`)
+	lintBulletPattern        = regexp.MustCompile(`^((?:[ \t]*>)*[ \t]*)([-*+])[ \t]+\S`)
+	lintOrderedPattern       = regexp.MustCompile(`^(?:[ \t]*>)*[ \t]*\d{1,9}[.)][ \t]+\S`)
+	lintThematicBreakPattern = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	// lintDirectivePattern matches the comments that turn rules off and
+	// on, also in markdownlint's spelling:
+	//
+	//	<!-- mdreader-lint-disable line-length -->
+	//	<!-- mdreader-lint-enable -->
+	//	<!-- mdreader-lint-disable-next-line MD034 -->
+	lintDirectivePattern = regexp.MustCompile(`<!--\s*(?:mdreader-lint|markdownlint)-(disable-next-line|disable-line|disable|enable)\b(.*?)-->`)
+)
+
+// runLint implements "mdreader lint": it checks the style of every
+// Markdown file under the given paths, optionally fixing what can be fixed
+// without changing the rendered output, and exits with 1 if problems
+// remain, or 2 on usage errors.
+func runLint(args []string) {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	var format string
+	var fix bool
+	var render renderFlags
+	fs.StringVar(&format, "format", "text", "Output format: text or json")
+	fs.BoolVar(&fix, "fix", false, "Rewrite files to fix the problems that can be fixed safely")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: mdreader lint <docs/ | file.md>... [options]")
+		fs.PrintDefaults()
+	}
+	render.register(fs)
+
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !validFormat(format) {
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q, use text or json\n", format)
+		os.Exit(2)
+	}
+	render.apply(fs, positional[0])
+	settings, err := newLintSettings(render.config.Lint)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	var diagnostics []diagnostic
+	files, fixed := 0, 0
+	for _, arg := range positional {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		inputs := []string{arg}
+		if info.IsDir() {
+			inputs = markdownFiles(arg)
+		}
+		for _, file := range inputs {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(2)
+			}
+			problems := lintMarkdown(file, source, settings)
+			if fix {
+				if fixedSource, n := fixMarkdown(source, problems); n > 0 {
+					if err := writeFixed(file, fixedSource); err != nil {
+						fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+						os.Exit(2)
+					}
+					fixed += n
+					problems = lintMarkdown(file, fixedSource, settings)
+				}
+			}
+			for _, p := range problems {
+				diagnostics = append(diagnostics, p.diagnostic)
+			}
+			files++
+		}
+	}
+
+	sortDiagnostics(diagnostics)
+	if err := writeDiagnostics(os.Stdout, format, diagnostics); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if format == "text" {
+		summary := fmt.Sprintf("Linted %d files: %d problems", files, len(diagnostics))
+		if fix {
+			summary += fmt.Sprintf(", %d fixed", fixed)
+		}
+		fmt.Fprintln(os.Stderr, summary)
+	}
+	if len(diagnostics) > 0 {
+		os.Exit(1)
+	}
+}
+
+// writeFixed replaces the contents of file, keeping its permissions.
+func writeFixed(file string, content []byte) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, content, info.Mode().Perm())
+}
+
+// lintSettings holds the configuration of every rule, by rule name.
+type lintSettings map[string]LintRuleConfig
+
+// newLintSettings applies the lint section of the config file to the
+// defaults, rejecting unknown rules and values.
+func newLintSettings(config map[string]LintRuleConfig) (lintSettings, error) {
+	settings := lintSettings{}
+	for _, rule := range lintRules {
+		settings[rule.name] = LintRuleConfig{Severity: "warning", Max: 80, Style: "consistent"}
+	}
+	for name, cfg := range config {
+		rule := findLintRule(name)
+		if rule == nil {
+			return nil, fmt.Errorf("unknown lint rule %q", name)
+		}
+		s := settings[rule.name]
+		s.Disabled = cfg.Disabled
+		switch cfg.Severity {
+		case "":
+		case "error", "warning", "info":
+			s.Severity = cfg.Severity
+		default:
+			return nil, fmt.Errorf("lint rule %s: unknown severity %q, use error, warning or info", rule.name, cfg.Severity)
+		}
+		if cfg.Max < 0 {
+			return nil, fmt.Errorf("lint rule %s: max must be positive", rule.name)
+		} else if cfg.Max > 0 {
+			s.Max = cfg.Max
+		}
+		switch cfg.Style {
+		case "":
+		case "consistent", "dash", "asterisk", "plus":
+			s.Style = cfg.Style
+		default:
+			return nil, fmt.Errorf("lint rule %s: unknown style %q, use consistent, dash, asterisk or plus", rule.name, cfg.Style)
+		}
+		settings[rule.name] = s
+	}
+	return settings, nil
+}
+
+// findLintRule looks a rule up by name or markdownlint ID.
+func findLintRule(name string) *lintRule {
+	for i, rule := range lintRules {
+		if strings.EqualFold(name, rule.name) || strings.EqualFold(name, rule.id) {
+			return &lintRules[i]
+		}
+	}
+	return nil
+}
+
+// lintProblem is a diagnostic together with the edits that fix it, if it
+// can be fixed safely.
+type lintProblem struct {
+	diagnostic
+	edits []lintEdit
+}
+
+// lintEdit replaces the bytes start to end of a line.
+type lintEdit struct {
+	line, start, end int
+	text             string
+}
+
+// lintDocument is a Markdown file being linted: its lines, what a scan of
+// them found, and the parsed tree. Nodes of the tree carry no positions,
+// so rules find the lines of headings and code blocks by taking those the
+// scan found in order.
+type lintDocument struct {
+	file     string
+	lines    []string // without line endings
+	first    int      // first line after the front matter
+	code     []bool   // inside a code block, fences included
+	masked   []string // lines with code spans blanked
+	fences   []int    // opening fence lines
+	headings []int    // ATX heading lines and setext heading text lines
+	bullets  []int    // list item lines with a -, * or + marker
+	disabled []map[string]bool
+	doc      *blackfriday.Node
+
+	rule     *lintRule
+	config   LintRuleConfig
+	problems []lintProblem
+}
+
+// lintMarkdown runs the enabled rules on source and returns the problems
+// found, ordered by position.
+func lintMarkdown(file string, source []byte, settings lintSettings) []lintProblem {
+	d := newLintDocument(file, source)
+	for i := range lintRules {
+		rule := &lintRules[i]
+		config := settings[rule.name]
+		if config.Disabled {
+			continue
+		}
+		d.rule, d.config = rule, config
+		rule.check(d)
+	}
+	sort.SliceStable(d.problems, func(i, j int) bool {
+		a, b := d.problems[i], d.problems[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return d.problems
+}
+
+func newLintDocument(file string, source []byte) *lintDocument {
+	text := strings.TrimSuffix(string(source), "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	// The front matter comes back as blank lines, which rules skip.
+	_, body := splitFrontMatter(source)
+	first := 0
+	if string(body) != string(source) {
+		first = len(body) - len(strings.TrimLeft(string(body), "\n"))
+	}
+	d := &lintDocument{
+		file:     file,
+		lines:    lines,
+		first:    first,
+		code:     make([]bool, len(lines)),
+		masked:   make([]string, len(lines)),
+		disabled: make([]map[string]bool, len(lines)),
+		doc:      blackfriday.New(blackfriday.WithExtensions(documentExtensions())).Parse(body),
+	}
+	d.scan()
+	d.scanDirectives()
+	return d
+}
+
+// scan finds the code blocks, headings and list items of the document.
+func (d *lintDocument) scan() {
+	code := newCodeScanner()
+	inList := false
+	// breaks is whether the previous line ends a paragraph, so a list
+	// can start on this one.
+	breaks := true
+	for i := d.first; i < len(d.lines); i++ {
+		line := d.lines[i]
+		opening := code.fence.open == ""
+		if code.inside(line) {
+			d.code[i] = true
+			if opening && code.fence.open != "" {
+				d.fences = append(d.fences, i)
+			} else {
+				breaks = true
+			}
+			continue
+		}
+		d.masked[i] = maskCodeSpans(line)
+
+		blank := strings.TrimSpace(line) == ""
+		bullet := lintBulletPattern.MatchString(line) && !lintThematicBreakPattern.MatchString(line)
+		ordered := lintOrderedPattern.MatchString(line)
+		heading := false
+		switch {
+		case blank:
+		case lintATXHeadingPattern.MatchString(line):
+			d.headings = append(d.headings, i)
+			heading = true
+		case bullet && (inList || breaks):
+			d.bullets = append(d.bullets, i)
+			inList = true
+		case ordered && (inList || breaks):
+			inList = true
+		case i+1 < len(d.lines) && !bullet && !ordered && lintSetextPattern.MatchString(d.lines[i+1]) &&
+			!lintThematicBreakPattern.MatchString(line):
+			d.headings = append(d.headings, i)
+			heading = true
+			i++
+			code.inside(d.lines[i])
+		case breaks && line[0] != ' ' && line[0] != '\t':
+			inList = false
+		}
+		breaks = blank || heading || lintThematicBreakPattern.MatchString(line)
+	}
+}
+
+// scanDirectives works out which rules are turned off on each line by
+// disable and enable comments.
+func (d *lintDocument) scanDirectives() {
+	current := map[string]bool{}
+	var next []string
+	for i := d.first; i < len(d.lines); i++ {
+		var thisLine, following []string
+		if !d.code[i] {
+			for _, m := range lintDirectivePattern.FindAllStringSubmatch(d.lines[i], -1) {
+				rules := lintDirectiveRules(m[2])
+				switch m[1] {
+				case "disable":
+					current = setRulesDisabled(current, rules, true)
+				case "enable":
+					current = setRulesDisabled(current, rules, false)
+				case "disable-line":
+					thisLine = append(thisLine, rules...)
+				case "disable-next-line":
+					following = append(following, rules...)
+				}
+			}
+		}
+		d.disabled[i] = current
+		if off := append(thisLine, next...); len(off) > 0 {
+			d.disabled[i] = setRulesDisabled(current, off, true)
+		}
+		next = following
+	}
+}
+
+// lintDirectiveRules returns the rule names listed in a directive, or "*"
+// for all rules if none are.
+func lintDirectiveRules(list string) []string {
+	var rules []string
+	for _, name := range strings.Fields(strings.ReplaceAll(list, ",", " ")) {
+		if rule := findLintRule(name); rule != nil {
+			name = rule.name
+		}
+		rules = append(rules, strings.ToLower(name))
+	}
+	if len(rules) == 0 {
+		rules = []string{"*"}
+	}
+	return rules
+}
+
+// setRulesDisabled returns a copy of disabled with rules turned off or on.
+// Turning "*" off or on overrides every rule.
+func setRulesDisabled(disabled map[string]bool, rules []string, off bool) map[string]bool {
+	updated := map[string]bool{}
+	for name, value := range disabled {
+		updated[name] = value
+	}
+	for _, name := range rules {
+		if name == "*" {
+			updated = map[string]bool{}
+		}
+		updated[name] = off
+	}
+	return updated
+}
+
+// isDisabled reports whether the current rule is turned off on line.
+func (d *lintDocument) isDisabled(line int) bool {
+	disabled := d.disabled[line]
+	if off, ok := disabled[d.rule.name]; ok {
+		return off
+	}
+	return disabled["*"]
+}
+
+// report records a problem of the current rule at a byte offset in line,
+// unless the rule is turned off there.
+func (d *lintDocument) report(line, offset int, edits []lintEdit, format string, args ...interface{}) {
+	if d.isDisabled(line) {
+		return
+	}
+	d.problems = append(d.problems, lintProblem{
+		diagnostic: diagnostic{
+			File:     d.file,
+			Line:     line + 1,
+			Column:   utf8.RuneCountInString(d.lines[line][:offset]) + 1,
+			Severity: d.config.Severity,
+			Rule:     d.rule.name,
+			Message:  fmt.Sprintf(format, args...),
+		},
+		edits: edits,
+	})
+}
+
+// lintHeading is a heading of the tree and the line it is on.
+type lintHeading struct {
+	level int
+	line  int
+}
+
+// headingLines pairs the headings of the tree with the heading lines found
+// by the scan.
+func (d *lintDocument) headingLines() []lintHeading {
+	var headings []lintHeading
+	d.doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering || node.Type != blackfriday.Heading || node.IsTitleblock {
+			return blackfriday.GoToNext
+		}
+		if len(headings) == len(d.headings) {
+			return blackfriday.Terminate
+		}
+		headings = append(headings, lintHeading{level: node.Level, line: d.headings[len(headings)]})
+		return blackfriday.GoToNext
+	})
+	return headings
+}
+
+// indentOf returns the length of the leading whitespace of line.
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// lintHeadingIncrement reports headings more than one level below the
+// previous heading, such as an h3 right after an h1.
+func lintHeadingIncrement(d *lintDocument) {
+	previous := 0
+	for _, h := range d.headingLines() {
+		if previous > 0 && h.level > previous+1 {
+			d.report(h.line, indentOf(d.lines[h.line]), nil, "heading level jumps from h%d to h%d", previous, h.level)
+		}
+		previous = h.level
+	}
+}
+
+// lintSingleH1 reports every top-level heading after the first.
+func lintSingleH1(d *lintDocument) {
+	first := -1
+	for _, h := range d.headingLines() {
+		if h.level != 1 {
+			continue
+		}
+		if first < 0 {
+			first = h.line
+			continue
+		}
+		d.report(h.line, indentOf(d.lines[h.line]), nil, "more than one top-level heading (the first is on line %d)", first+1)
+	}
+}
+
+// lintTrailingSpaces reports whitespace at the end of lines outside code.
+// Exactly two spaces before another line of the paragraph are a line
+// break and are allowed; longer runs there are cut to two, so the break
+// stays.
+func lintTrailingSpaces(d *lintDocument) {
+	for i := d.first; i < len(d.lines); i++ {
+		if d.code[i] {
+			continue
+		}
+		line := d.lines[i]
+		trimmed := strings.TrimRight(line, " \t")
+		if trimmed == line {
+			continue
+		}
+		trailing := line[len(trimmed):]
+		lineBreak := trimmed != "" && strings.HasSuffix(trailing, "  ") && i+1 < len(d.lines) && continuesParagraph(d, i+1)
+		if lineBreak && trailing == "  " {
+			continue
+		}
+		replacement := ""
+		if lineBreak {
+			replacement = "  "
+		}
+		d.report(i, len(trimmed), []lintEdit{{line: i, start: len(trimmed), end: len(line), text: replacement}}, "trailing whitespace")
+	}
+}
+
+// continuesParagraph reports whether line can continue the paragraph
+// above it rather than start a block of its own.
+func continuesParagraph(d *lintDocument, line int) bool {
+	text := d.lines[line]
+	return !d.code[line] && strings.TrimSpace(text) != "" && !lintATXHeadingPattern.MatchString(text) &&
+		!lintBulletPattern.MatchString(text) && !lintOrderedPattern.MatchString(text) && !lintThematicBreakPattern.MatchString(text)
+}
+
+// lintListMarkers reports unordered list items whose marker differs from
+// the configured one, or from the first one used in the document.
+func lintListMarkers(d *lintDocument) {
+	markers := map[string]byte{"dash": '-', "asterisk": '*', "plus": '+'}
+	want := markers[d.config.Style]
+	for _, i := range d.bullets {
+		m := lintBulletPattern.FindStringSubmatchIndex(d.lines[i])
+		offset := m[4]
+		marker := d.lines[i][offset]
+		if want == 0 {
+			want = marker
+			continue
+		}
+		if marker != want {
+			d.report(i, offset, []lintEdit{{line: i, start: offset, end: offset + 1, text: string(want)}},
+				"list marker %q should be %q", marker, want)
+		}
+	}
+}
+
+// lintLineLength reports lines outside code longer than the configured
+// maximum. Lines that only run over because of a long word, such as a
+// URL, are allowed.
+func lintLineLength(d *lintDocument) {
+	max := d.config.Max
+	for i := d.first; i < len(d.lines); i++ {
+		line := d.lines[i]
+		length := utf8.RuneCountInString(line)
+		if d.code[i] || length <= max {
+			continue
+		}
+		offset := 0
+		for n := 0; n < max; n++ {
+			_, size := utf8.DecodeRuneInString(line[offset:])
+			offset += size
+		}
+		if !strings.ContainsAny(line[offset:], " \t") {
+			continue
+		}
+		d.report(i, offset, nil, "line is %d characters long, more than %d", length, max)
+	}
+}
+
+// lintFenceLanguage reports fenced code blocks without an info string.
+func lintFenceLanguage(d *lintDocument) {
+	n := 0
+	d.doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering || node.Type != blackfriday.CodeBlock || !node.IsFenced {
+			return blackfriday.GoToNext
+		}
+		if n == len(d.fences) {
+			return blackfriday.Terminate
+		}
+		line := d.fences[n]
+		n++
+		if strings.TrimSpace(string(node.Info)) == "" {
+			offset := strings.IndexAny(d.lines[line], "`~")
+			d.report(line, offset, nil, "fenced code block has no language")
+		}
+		return blackfriday.GoToNext
+	})
+}
+
+// lintBareURLs reports URLs that are only links because of autolinking,
+// and fixes them by wrapping them in angle brackets. The tree tells which
+// links are URLs standing for themselves; the first later occurrence of
+// each in the source tells how it was written.
+func lintBareURLs(d *lintDocument) {
+	var urls []string
+	d.doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && node.Type == blackfriday.Link && node.FirstChild != nil && node.FirstChild == node.LastChild &&
+			node.FirstChild.Type == blackfriday.Text && string(node.FirstChild.Literal) == string(node.LinkData.Destination) &&
+			strings.Contains(string(node.LinkData.Destination), "://") {
+			urls = append(urls, string(node.LinkData.Destination))
+		}
+		return blackfriday.GoToNext
+	})
+
+	line, offset := d.first, 0
+	for _, u := range urls {
+		l, o, found := d.findURL(u, line, offset)
+		if !found {
+			continue
+		}
+		line, offset = l, o+len(u)
+		if before := d.masked[l][:o]; strings.HasSuffix(before, "<") || strings.HasSuffix(before, "[") {
+			continue
+		}
+		d.report(l, o, []lintEdit{{line: l, start: o, end: o + len(u), text: "<" + u + ">"}}, "bare URL %s", u)
+	}
+}
+
+// findURL returns the next occurrence of u outside code from line and
+// offset on, skipping link destinations and HTML attributes.
+func (d *lintDocument) findURL(u string, line, offset int) (int, int, bool) {
+	for ; line < len(d.lines); line, offset = line+1, 0 {
+		if d.code[line] {
+			continue
+		}
+		text := d.masked[line]
+		for offset <= len(text) {
+			i := strings.Index(text[offset:], u)
+			if i < 0 {
+				break
+			}
+			start := offset + i
+			offset = start + len(u)
+			if start > 0 && strings.ContainsRune("(\"'=", rune(text[start-1])) {
+				continue
+			}
+			return line, start, true
+		}
+	}
+	return 0, 0, false
+}
+
+// fixMarkdown applies the edits of problems to source and returns the
+// result with the number of problems fixed. Edits overlapping one already
+// applied are left for a later run.
+func fixMarkdown(source []byte, problems []lintProblem) ([]byte, int) {
+	var edits []lintEdit
+	for _, p := range problems {
+		edits = append(edits, p.edits...)
+	}
+	if len(edits) == 0 {
+		return source, 0
+	}
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].line != edits[j].line {
+			return edits[i].line < edits[j].line
+		}
+		return edits[i].start > edits[j].start
+	})
+
+	text := string(source)
+	newline := "\n"
+	if strings.Contains(text, "\r\n") {
+		newline = "\r\n"
+	}
+	finalNewline := strings.HasSuffix(text, "\n")
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+
+	fixed := 0
+	previous := lintEdit{line: -1}
+	for _, e := range edits {
+		if e.line == previous.line && e.end > previous.start {
+			continue
+		}
+		lines[e.line] = lines[e.line][:e.start] + e.text + lines[e.line][e.end:]
+		previous = e
+		fixed++
+	}
+	result := strings.Join(lines, newline)
+	if finalNewline {
+		result += newline
+	}
+	return []byte(result), fixed
+}
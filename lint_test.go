@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+// lintFixSources are documents with fixable problems next to code that
+// must come through --fix untouched.
+var lintFixSources = map[string]string{
+	"indented code": "# Title\n\nExample:\n\n    keep  \n    * star\n\ntrailing  \n",
+	"fenced code":   "# Title\n\n````sh\n```\nkeep  \n````\n\n* one\n- two\n",
+	"list":          "# Title\n\n- one\n* two\n\n    after the list  \n",
+	"front matter":  "---\ntitle: x\n---\nTitle\n=====\n\ntext   \n",
+}
+
+func TestLintFixKeepsRenderedHTML(t *testing.T) {
+	settings, err := newLintSettings(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, source := range lintFixSources {
+		problems := lintMarkdown(name+".md", []byte(source), settings)
+		fixed, n := fixMarkdown([]byte(source), problems)
+		if n == 0 {
+			t.Errorf("%s: nothing fixed", name)
+		}
+		before, after := convertMarkdownToHTML([]byte(source)), convertMarkdownToHTML(fixed)
+		if before != after {
+			t.Errorf("%s: --fix changed the rendered HTML\nsource:\n%s\nfixed:\n%s", name, source, fixed)
+		}
+	}
+}
+
+func TestLintSkipsIndentedCode(t *testing.T) {
+	settings, err := newLintSettings(map[string]LintRuleConfig{"line-length": {Max: 20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := "Example:\n\n    a very long line of code that is indented  \n\nText\n    a long continuation line of the paragraph\n"
+	var lines []int
+	for _, p := range lintMarkdown("code.md", []byte(source), settings) {
+		lines = append(lines, p.Line)
+	}
+	if len(lines) != 1 || lines[0] != 6 {
+		t.Errorf("problems on lines %v, want only line 6", lines)
+	}
+}
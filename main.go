@@ -4,13 +4,18 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/alecthomas/chroma/v2"
 	"github.com/alecthomas/chroma/v2/formatters/html"
@@ -20,73 +25,315 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "build" {
+		runBuild(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		runCheck(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		runLint(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		runFmt(os.Args[2:])
+		return
+	}
+
 	var inputFile string
 	var outputFile string
 	var launch bool
 	var ui bool
+	var render renderFlags
 
 	flag.StringVar(&inputFile, "input", "", "Input markdown file")
 	flag.StringVar(&outputFile, "output", "", "Output HTML file (optional)")
 	flag.BoolVar(&launch, "launch", false, "Launch HTML file in default browser")
 	flag.BoolVar(&ui, "ui", false, "Launch interactive UI editor")
-	flag.Parse()
+	render.register(flag.CommandLine)
+
+	// Accept flags before and after the input files, as in
+	// "mdreader doc.md --launch".
+	var inputs []string
+	args := os.Args[1:]
+	for {
+		flag.CommandLine.Parse(args)
+		if flag.NArg() == 0 {
+			break
+		}
+		inputs = append(inputs, flag.Arg(0))
+		args = flag.Args()[1:]
+	}
+
+	inputs = inputList(inputFile, inputs)
+	if len(inputs) > 0 {
+		inputFile = inputs[0]
+	}
+
+	setFlags := render.apply(flag.CommandLine, filepath.Dir(inputFile))
+
+	// The UI previews whatever is typed or opened, so it sanitizes unless
+	// told otherwise.
+	if ui && !setFlags["safe"] {
+		options.Safe = true
+	}
+
+	options.BaseDir = filepath.Dir(inputFile)
 
 	if ui {
 		// UI mode - can optionally load a file
-		if inputFile == "" && flag.NArg() > 0 {
-			inputFile = flag.Arg(0)
+		lint, err := newLintSettings(render.config.Lint)
+		if err != nil {
+			log.Fatalf("Error loading config: %v", err)
 		}
-		runUI(inputFile)
+		runUI(inputFile, lint, newFormatStyle(render.config, lint))
 		return
 	}
 
-	if inputFile == "" && flag.NArg() > 0 {
-		inputFile = flag.Arg(0)
-	}
-
 	if inputFile == "" {
 		fmt.Println("Usage: mdreader <input.md> [--output <output.html>] [--launch]")
 		fmt.Println("       mdreader --input <input.md> [--output <output.html>] [--launch]")
+		fmt.Println("       mdreader --output <dir> <a.md> <b.md> ...  # Convert several files")
 		fmt.Println("       mdreader --ui [input.md]  # Launch interactive editor")
+		fmt.Println("       mdreader build <docs/> [-o <site/>]  # Build a documentation site")
+		fmt.Println("       mdreader check <docs/>  # Check links, images and anchors")
+		fmt.Println("       mdreader lint <docs/> [--fix]  # Check Markdown style")
+		fmt.Println("       mdreader fmt <docs/> [--check]  # Rewrite Markdown in a canonical style")
 		os.Exit(1)
 	}
 
+	// Several inputs form a batch: --output names a directory and every
+	// file keeps its base name. Assets are shared across the batch.
+	if len(inputs) > 1 {
+		outputDir := outputFile
+		if outputDir == "" {
+			outputDir = "."
+		}
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			log.Fatalf("Error creating output directory: %v", err)
+		}
+		written := map[string]string{}
+		for _, input := range inputs {
+			output := filepath.Join(outputDir, htmlFileName(input))
+			if other, ok := written[output]; ok {
+				log.Fatalf("Error: %s and %s would both be written to %s", other, input, output)
+			}
+			written[output] = input
+		}
+		if err := convertBatch(inputs, outputDir); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
 	if outputFile == "" {
-		base := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
-		outputFile = base + ".html"
+		outputFile = htmlFileName(inputFile)
+	}
+
+	if err := convertFile(inputFile, outputFile); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	fmt.Printf("HTML file created: %s\n", outputFile)
+
+	if launch {
+		if err := openBrowser(outputFile); err != nil {
+			log.Printf("Error opening browser: %v", err)
+		}
 	}
+}
+
+// htmlFileName returns the default output name for a Markdown file.
+func htmlFileName(inputFile string) string {
+	return strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile)) + ".html"
+}
 
+// convertFile converts one Markdown file to a standalone HTML page. Local
+// images and files are resolved against the input's directory and copied
+// next to the output.
+func convertFile(inputFile, outputFile string) error {
 	markdown, err := os.ReadFile(inputFile)
 	if err != nil {
-		log.Fatalf("Error reading input file: %v", err)
+		return fmt.Errorf("reading input file: %v", err)
 	}
 
+	setDocumentOptions(inputFile, outputFile)
 	htmlContent := convertMarkdownToHTML(markdown)
 
-	err = os.WriteFile(outputFile, []byte(htmlContent), 0644)
-	if err != nil {
-		log.Fatalf("Error writing output file: %v", err)
+	if err := os.WriteFile(outputFile, []byte(htmlContent), 0644); err != nil {
+		return fmt.Errorf("writing output file: %v", err)
 	}
+	return nil
+}
 
-	fmt.Printf("HTML file created: %s\n", outputFile)
+// batchDocument is a file of a batch, parsed and waiting to be rendered.
+type batchDocument struct {
+	input, output string
+	meta          FrontMatter
+	renderer      *CustomHTMLRenderer
+	doc           *blackfriday.Node
+}
 
-	if launch {
-		err = openBrowser(outputFile)
+// inputList puts the file named by --input, if any, in front of the files
+// given as arguments, so that "--input a.md b.md" converts both.
+func inputList(named string, args []string) []string {
+	if named == "" || (len(args) > 0 && args[0] == named) {
+		return args
+	}
+	return append([]string{named}, args...)
+}
+
+// convertBatch converts several files into outputDir. All of them are
+// parsed before any is written, so each page can end with the "Linked
+// references" of the other pages in the batch that link to it.
+func convertBatch(inputs []string, outputDir string) error {
+	if options.WikiRoot == "" {
+		options.WikiRoot = commonDir(inputs)
+	}
+
+	// Every page lands in outputDir, so links between the files of the
+	// batch go through the table of their pages.
+	options.MarkdownLinks = true
+	options.Pages = map[string]string{}
+	for _, input := range inputs {
+		file, _ := filepath.Abs(input)
+		options.Pages[file], _ = filepath.Abs(filepath.Join(outputDir, htmlFileName(input)))
+	}
+
+	documents := make([]batchDocument, len(inputs))
+	linked := make([]linkedDocument, len(inputs))
+	outputs := map[string]string{}
+	for i, input := range inputs {
+		source, err := os.ReadFile(input)
 		if err != nil {
-			log.Printf("Error opening browser: %v", err)
+			return fmt.Errorf("reading input file: %v", err)
+		}
+		d := &documents[i]
+		d.input = input
+		d.output = filepath.Join(outputDir, htmlFileName(input))
+		setDocumentOptions(d.input, d.output)
+		var markdown []byte
+		d.meta, markdown = splitFrontMatter(source)
+		d.renderer, d.doc = parseMarkdown(markdown)
+
+		file, _ := filepath.Abs(input)
+		title := d.meta.Title
+		if title == "" {
+			title = firstHeading(markdown)
+		}
+		if title == "" {
+			title = filepath.Base(input)
 		}
+		linked[i] = linkedDocument{File: file, Title: title, Links: d.renderer.links}
+		outputs[file] = filepath.Base(d.output)
 	}
+
+	backlinks := findBacklinks(linked)
+	for i, d := range documents {
+		setDocumentOptions(d.input, d.output)
+		body := d.renderer.renderDocument(d.doc)
+		body += renderBacklinks(backlinks[linked[i].File], func(ref backlink) string {
+			return (&url.URL{Path: outputs[ref.File]}).String()
+		})
+		if err := os.WriteFile(d.output, []byte(htmlPage(d.meta, body)), 0644); err != nil {
+			return fmt.Errorf("writing output file: %v", err)
+		}
+		fmt.Printf("HTML file created: %s\n", d.output)
+	}
+	return nil
+}
+
+// commonDir returns the deepest directory holding all of files, so wiki
+// links of a batch resolve across it.
+func commonDir(files []string) string {
+	var dir string
+	for i, file := range files {
+		abs, err := filepath.Abs(filepath.Dir(file))
+		if err != nil {
+			return ""
+		}
+		if i == 0 {
+			dir = abs
+			continue
+		}
+		for dir != abs && !strings.HasPrefix(abs, dir+string(filepath.Separator)) && filepath.Dir(dir) != dir {
+			dir = filepath.Dir(dir)
+		}
+	}
+	return dir
+}
+
+// setDocumentOptions points relative URLs, copied assets and warnings at
+// the file being converted.
+func setDocumentOptions(inputFile, outputFile string) {
+	options.BaseDir = filepath.Dir(inputFile)
+	options.OutputDir = filepath.Dir(outputFile)
+	options.Source = inputFile
+}
+
+// RenderOptions holds the settings that shape conversion. main fills it in
+// from the command line before anything is rendered.
+type RenderOptions struct {
+	Extensions Extension
+
+	Diagrams       bool
+	DiagramTimeout time.Duration
+
+	CopyButtons  bool
+	CollapseCode int
+
+	DetectLanguage  bool
+	LanguageAliases map[string]string
+
+	Safe           bool
+	SanitizePolicy SanitizePolicy
+
+	// BaseDir is where relative URLs are resolved, and OutputDir where the
+	// assets folder is created; OutputDir is empty in the UI.
+	EmbedImages  bool
+	EmbedMaxSize int64
+	CopyAssets   bool
+	HashAssets   bool
+	AssetsDir    string
+	BaseDir      string
+	OutputDir    string
+
+	// MarkdownLinks rewrites links to .md files to the .html pages they
+	// are converted to. When the pages do not mirror the source tree, as
+	// in a batch, Pages maps each converted file to its page, both by
+	// absolute path, and links to other Markdown files are left alone.
+	MarkdownLinks bool
+	Pages         map[string]string
+
+	// WikiRoot is the directory [[wiki links]] are looked up in, BaseDir
+	// if empty. Source names the document being converted in warnings.
+	WikiRoot string
+	Source   string
+}
+
+var options = RenderOptions{
+	SanitizePolicy: defaultSanitizePolicy(),
+	Extensions:     ExtAll,
+	DiagramTimeout: 10 * time.Second,
+	EmbedMaxSize:   1 << 20,
+	AssetsDir:      "assets",
 }
 
 func convertMarkdownToHTML(markdown []byte) string {
-	body := convertMarkdownToHTMLBody(markdown)
+	meta, markdown := splitFrontMatter(markdown)
+	return htmlPage(meta, convertMarkdownToHTMLBody(markdown))
+}
 
+// htmlPage wraps a rendered body in a standalone page.
+func htmlPage(meta FrontMatter, body string) string {
 	html := fmt.Sprintf(`<!DOCTYPE html>
 <html>
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Markdown Preview</title>
+    <title>%s</title>
     <style>
         %s
         %s
@@ -96,20 +343,86 @@ func convertMarkdownToHTML(markdown []byte) string {
     <div class="markdown-body">
         %s
     </div>
+    %s
 </body>
-</html>`, getGithubCSS(), getChromaCSS(), body)
+</html>`, pageTitle(meta), getGithubCSS(), getChromaCSS(), body, getCodeToolsScript(meta))
 
 	return html
 }
 
+func pageTitle(meta FrontMatter) string {
+	if meta.Title == "" {
+		return "Markdown Preview"
+	}
+	return template.HTMLEscapeString(meta.Title)
+}
+
 func convertMarkdownToHTMLBody(markdown []byte) string {
+	renderer, doc := parseMarkdown(markdown)
+	return renderer.renderDocument(doc)
+}
+
+// parseMarkdown runs the source pre-passes and the parser and applies the
+// AST transforms, returning the tree and the renderer that holds the state
+// needed to render it.
+func parseMarkdown(markdown []byte) (*CustomHTMLRenderer, *blackfriday.Node) {
+	renderer, doc := parseSource(markdown)
+	renderer.transformAlerts(doc)
+	renderer.links = collectDocumentLinks(doc, options.BaseDir, renderer.math)
+	renderer.resolveWikiLinks(doc)
+	if options.EmbedImages || options.CopyAssets || options.MarkdownLinks {
+		rewriteLocalURLs(doc)
+	}
+	return renderer, doc
+}
+
+// parseSource runs the source pre-passes and the parser only, giving the
+// tree, and heading IDs, exactly as rendering would see them.
+func parseSource(markdown []byte) (*CustomHTMLRenderer, *blackfriday.Node) {
 	renderer := NewCustomHTMLRenderer()
-	extensions := blackfriday.CommonExtensions | blackfriday.AutoHeadingIDs | blackfriday.FencedCode | blackfriday.Tables
-	body := blackfriday.Run(markdown, blackfriday.WithRenderer(renderer), blackfriday.WithExtensions(extensions))
-	return string(body)
+	var lineMap []int
+	if options.Extensions&ExtIncludes != 0 {
+		markdown, lineMap = expandIncludes(markdown, options.BaseDir)
+	}
+	if options.Extensions&ExtAbbreviations != 0 {
+		markdown, renderer.abbreviations = extractAbbreviations(markdown)
+	}
+	markdown = expandAlertFences(markdown)
+	if options.Extensions&ExtMath != 0 {
+		markdown, renderer.math = extractMath(markdown)
+	}
+	if options.Extensions&ExtWikiLinks != 0 {
+		markdown = expandWikiLinks(markdown)
+	}
+	renderer.taskLines = sourceLines(findTaskLines(markdown), lineMap)
+	parser := blackfriday.New(blackfriday.WithRenderer(renderer), blackfriday.WithExtensions(documentExtensions()))
+	doc := parser.Parse(markdown)
+	fixMathHeadingIDs(markdown, doc, renderer.math)
+	// A scan that disagrees with the parser would point checkboxes at the
+	// wrong lines; leave them without one instead.
+	if countTasks(doc) != len(renderer.taskLines) {
+		renderer.taskLines = nil
+	}
+	return renderer, doc
+}
+
+// renderDocument renders a tree returned by parseMarkdown to HTML.
+func (r *CustomHTMLRenderer) renderDocument(doc *blackfriday.Node) string {
+	var buf bytes.Buffer
+	r.RenderHeader(&buf, doc)
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		return r.RenderNode(&buf, node, entering)
+	})
+	r.RenderFooter(&buf, doc)
+	body := restoreMath(buf.String(), r.math)
+	if options.Safe {
+		body = sanitizeHTML(body, options.SanitizePolicy)
+	}
+	return body
 }
 
 func convertMarkdownToHTMLForUI(markdown []byte) string {
+	meta, markdown := splitFrontMatter(markdown)
 	body := convertMarkdownToHTMLBody(markdown)
 
 	html := fmt.Sprintf(`<!DOCTYPE html>
@@ -117,7 +430,7 @@ func convertMarkdownToHTMLForUI(markdown []byte) string {
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Markdown Preview</title>
+    <title>%s</title>
     <style>
         %s
         %s
@@ -127,66 +440,204 @@ func convertMarkdownToHTMLForUI(markdown []byte) string {
     <div class="markdown-body">
         %s
     </div>
+    %s
 </body>
-</html>`, getGithubCSS(), getChromaCSS(), body)
+</html>`, pageTitle(meta), getGithubCSS(), getChromaCSS(), body, getCodeToolsScript(meta))
 
 	return html
 }
 
 type CustomHTMLRenderer struct {
 	*blackfriday.HTMLRenderer
+
+	// taskLines holds the source line of each task list item so the
+	// rendered checkboxes can be mapped back to the Markdown.
+	taskLines []int
+	taskIndex int
+
+	extensions          Extension
+	abbreviations       map[string]string
+	abbreviationPattern *regexp.Regexp
+
+	// math holds the TeX spans replaced by placeholders in the source.
+	math []mathSpan
+
+	// alerts and alertTitles are filled in by transformAlerts.
+	alerts      map[*blackfriday.Node]alert
+	alertTitles map[*blackfriday.Node]alert
+
+	// links holds the document's links to other Markdown files, for
+	// backlinks, and brokenWikiLinks the targets of unresolved wiki links.
+	links           []docLink
+	brokenWikiLinks map[*blackfriday.Node]string
 }
 
 func NewCustomHTMLRenderer() *CustomHTMLRenderer {
 	return &CustomHTMLRenderer{
 		HTMLRenderer: blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
-			Flags: blackfriday.CommonHTMLFlags,
+			Flags:                      blackfriday.CommonHTMLFlags | blackfriday.FootnoteReturnLinks,
+			FootnoteReturnLinkContents: "&#8617;",
 		}),
+		extensions:  options.Extensions,
+		alerts:      map[*blackfriday.Node]alert{},
+		alertTitles: map[*blackfriday.Node]alert{},
+
+		brokenWikiLinks: map[*blackfriday.Node]string{},
 	}
 }
 
 func (r *CustomHTMLRenderer) RenderNode(w io.Writer, node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
 	if node.Type == blackfriday.CodeBlock {
 		if entering {
-			lang := string(node.CodeBlockData.Info)
-			highlighted := highlightCode(string(node.Literal), lang)
+			info := parseCodeBlockInfo(string(node.CodeBlockData.Info))
+			if info.Lang == "math" && r.extensions&ExtMath != 0 {
+				w.Write([]byte(renderMath(strings.TrimSpace(string(node.Literal)), true)))
+				return blackfriday.GoToNext
+			}
+			if options.Diagrams {
+				if diagram, ok := renderDiagram(info.Lang, string(node.Literal)); ok {
+					w.Write([]byte(diagram))
+					return blackfriday.GoToNext
+				}
+			}
+			highlighted := highlightCodeBlock(string(node.Literal), info)
 			w.Write([]byte(highlighted))
 		}
 		return blackfriday.GoToNext
 	}
+	if a, ok := r.alerts[node]; ok {
+		r.renderAlert(w, a, entering)
+		return blackfriday.GoToNext
+	}
+	if a, ok := r.alertTitles[node]; ok {
+		r.renderAlertTitle(w, node, a, entering)
+		return blackfriday.GoToNext
+	}
+	if target, ok := r.brokenWikiLinks[node]; ok {
+		io.WriteString(w, renderBrokenWikiLink(target, entering))
+		return blackfriday.GoToNext
+	}
+	if node.Type == blackfriday.Item && entering {
+		if _, ok := taskMarker(node); ok {
+			w.Write([]byte(`<li class="task-list-item">`))
+			return blackfriday.GoToNext
+		}
+	}
+	if node.Type == blackfriday.Text && entering {
+		if isTaskText(node) {
+			r.renderTaskCheckbox(w, node)
+		}
+		if r.extensions&(ExtAbbreviations|ExtHighlight|ExtSuperscript|ExtSubscript) != 0 {
+			var buf bytes.Buffer
+			status := r.HTMLRenderer.RenderNode(&buf, node, entering)
+			w.Write(r.applyInlineExtensions(buf.Bytes()))
+			return status
+		}
+	}
 	return r.HTMLRenderer.RenderNode(w, node, entering)
 }
 
 func highlightCode(code, lang string) string {
-	lang = strings.TrimSpace(strings.ToLower(lang))
-	
-	lexer := lexers.Get(lang)
-	if lexer == nil {
-		lexer = lexers.Fallback
+	return highlightCodeBlock(code, codeBlockInfo{Lang: strings.TrimSpace(strings.ToLower(lang)), StartLine: 1})
+}
+
+// highlightCodeBlock highlights code and applies the line numbers, line
+// highlights and title from the fence's info string.
+func highlightCodeBlock(code string, info codeBlockInfo) string {
+	lang := info.Lang
+
+	var diffLines []diffLine
+	if embedded, ok := diffLanguage(lang); ok {
+		lang = embedded
+		code, diffLines = stripDiffMarkers(code)
 	}
-	lexer = chroma.Coalesce(lexer)
+
+	lexer := chroma.Coalesce(resolveLexer(lang, code))
 
 	style := styles.Get("github")
 	if style == nil {
 		style = styles.Fallback
 	}
 
-	formatter := html.New(html.WithClasses(true), html.PreventSurroundingPre(false))
-	
+	formatterOptions := []html.Option{html.WithClasses(true), html.PreventSurroundingPre(false)}
+	if info.LineNumbers {
+		formatterOptions = append(formatterOptions, html.WithLineNumbers(true), html.BaseLineNumber(info.StartLine))
+	}
+	if len(info.Highlight) > 0 {
+		// Ranges count from the first line of the block; chroma matches
+		// them against the displayed line numbers.
+		ranges := make([][2]int, len(info.Highlight))
+		for i, hl := range info.Highlight {
+			ranges[i] = [2]int{hl[0] + info.StartLine - 1, hl[1] + info.StartLine - 1}
+		}
+		formatterOptions = append(formatterOptions, html.HighlightLines(ranges))
+	}
+	formatter := html.New(formatterOptions...)
+
 	iterator, err := lexer.Tokenise(nil, code)
 	if err != nil {
-		return fmt.Sprintf("<pre><code>%s</code></pre>", code)
+		return plainCodeBlock(code, lang)
 	}
 
 	var buf bytes.Buffer
 	err = formatter.Format(&buf, style, iterator)
 	if err != nil {
-		return fmt.Sprintf("<pre><code>%s</code></pre>", code)
+		return plainCodeBlock(code, lang)
 	}
 
-	return buf.String()
+	highlighted := buf.String()
+	if diffLines != nil {
+		highlighted = applyDiffLines(highlighted, diffLines)
+	}
+	if info.Title != "" {
+		return fmt.Sprintf("<div class=\"code-block\"><div class=\"code-title\">%s</div>%s</div>", template.HTMLEscapeString(info.Title), highlighted)
+	}
+	return highlighted
 }
 
+// plainCodeBlock is the fallback when highlighting fails. The code is
+// escaped so a block containing markup can never become live HTML.
+func plainCodeBlock(code, lang string) string {
+	class := ""
+	if lang != "" {
+		class = fmt.Sprintf(` class="language-%s"`, template.HTMLEscapeString(lang))
+	}
+	return fmt.Sprintf("<pre><code%s>%s</code></pre>", class, template.HTMLEscapeString(code))
+}
+
+// resolveLexer maps lang through the configured aliases to a chroma
+// lexer. Blocks without a language are guessed from their content when
+// --detect-language is on; unknown languages are reported and shown as
+// plain text.
+func resolveLexer(lang, code string) chroma.Lexer {
+	if alias, ok := options.LanguageAliases[lang]; ok {
+		lang = alias
+	}
+	if lang == "" {
+		if options.DetectLanguage {
+			if lexer := lexers.Analyse(code); lexer != nil {
+				return lexer
+			}
+		}
+		return lexers.Fallback
+	}
+	if lexer := lexers.Get(lang); lexer != nil {
+		return lexer
+	}
+	warnOnce(fmt.Sprintf("unknown code block language %q, showing plain text", lang))
+	return lexers.Fallback
+}
+
+// warnOnce logs each distinct warning a single time, so the UI does not
+// repeat it on every keystroke.
+func warnOnce(msg string) {
+	if _, seen := warnings.LoadOrStore(msg, true); !seen {
+		log.Printf("Warning: %s", msg)
+	}
+}
+
+var warnings sync.Map
+
 func getChromaCSS() string {
 	style := styles.Get("github")
 	if style == nil {
@@ -312,6 +763,19 @@ func getGithubCSS() string {
             margin-top: 0.25em;
         }
 
+        .markdown-body .task-list-item {
+            list-style-type: none;
+        }
+
+        .markdown-body .task-list-item + .task-list-item {
+            margin-top: 3px;
+        }
+
+        .markdown-body .task-list-item-checkbox {
+            margin: 0 0.2em 0.25em -1.6em;
+            vertical-align: middle;
+        }
+
         .markdown-body code {
             padding: 0.2em 0.4em;
             margin: 0;
@@ -409,6 +873,279 @@ func getGithubCSS() string {
             text-decoration: line-through;
         }
 
+        .markdown-body mark {
+            padding: 0.1em 0.2em;
+            background-color: #fff8c5;
+            color: inherit;
+            border-radius: 3px;
+        }
+
+        .markdown-body sup,
+        .markdown-body sub {
+            font-size: 75%;
+            line-height: 0;
+            position: relative;
+            vertical-align: baseline;
+        }
+
+        .markdown-body sup {
+            top: -0.5em;
+        }
+
+        .markdown-body sub {
+            bottom: -0.25em;
+        }
+
+        .markdown-body abbr[title] {
+            text-decoration: underline dotted;
+            cursor: help;
+        }
+
+        .markdown-body .wikilink-broken {
+            color: #cf222e;
+            text-decoration: underline dashed;
+            cursor: help;
+        }
+
+        .markdown-body .backlinks {
+            margin-top: 48px;
+            padding-top: 8px;
+            border-top: 1px solid #eaecef;
+            font-size: 14px;
+        }
+
+        .markdown-body .backlinks h2 {
+            font-size: 1.2em;
+            border-bottom: none;
+        }
+
+        .markdown-body .backlinks ul {
+            padding-left: 0;
+            list-style: none;
+        }
+
+        .markdown-body .backlinks p {
+            margin: 0 0 12px;
+            color: #6a737d;
+        }
+
+        .markdown-body dl {
+            padding: 0;
+            margin-top: 0;
+            margin-bottom: 16px;
+        }
+
+        .markdown-body dl dt {
+            padding: 0;
+            margin-top: 16px;
+            font-size: 1em;
+            font-style: italic;
+            font-weight: 600;
+        }
+
+        .markdown-body dl dd {
+            padding: 0 16px;
+            margin: 0 0 16px;
+        }
+
+        .markdown-body .footnotes {
+            font-size: 12px;
+            color: #6a737d;
+        }
+
+        .markdown-body .footnotes hr {
+            height: 1px;
+            margin: 32px 0 16px;
+        }
+
+        .markdown-body .footnotes ol {
+            padding-left: 16px;
+        }
+
+        .markdown-body .footnotes li:target {
+            color: #24292e;
+        }
+
+        .markdown-body .footnotes .footnote-return {
+            margin-left: 4px;
+        }
+
+        .markdown-body sup.footnote-ref a {
+            padding: 0 2px;
+        }
+
+        .markdown-body .markdown-alert {
+            padding: 8px 16px;
+            margin-bottom: 16px;
+            color: inherit;
+            border-left: 0.25em solid #d0d7de;
+        }
+
+        .markdown-body .markdown-alert > :first-child {
+            margin-top: 0;
+        }
+
+        .markdown-body .markdown-alert > :last-child {
+            margin-bottom: 0;
+        }
+
+        .markdown-body .markdown-alert .markdown-alert-title {
+            display: flex;
+            align-items: center;
+            font-weight: 500;
+            line-height: 1;
+            margin-bottom: 8px;
+        }
+
+        .markdown-body details.markdown-alert .markdown-alert-title {
+            cursor: pointer;
+        }
+
+        .markdown-body details.markdown-alert:not([open]) .markdown-alert-title {
+            margin-bottom: 0;
+        }
+
+        .markdown-body .markdown-alert .octicon {
+            margin-right: 8px;
+            fill: currentColor;
+        }
+
+        .markdown-body .markdown-alert-note {
+            border-left-color: #0969da;
+        }
+
+        .markdown-body .markdown-alert-note .markdown-alert-title {
+            color: #0969da;
+        }
+
+        .markdown-body .markdown-alert-tip {
+            border-left-color: #1a7f37;
+        }
+
+        .markdown-body .markdown-alert-tip .markdown-alert-title {
+            color: #1a7f37;
+        }
+
+        .markdown-body .markdown-alert-important {
+            border-left-color: #8250df;
+        }
+
+        .markdown-body .markdown-alert-important .markdown-alert-title {
+            color: #8250df;
+        }
+
+        .markdown-body .markdown-alert-warning {
+            border-left-color: #9a6700;
+        }
+
+        .markdown-body .markdown-alert-warning .markdown-alert-title {
+            color: #9a6700;
+        }
+
+        .markdown-body .markdown-alert-caution {
+            border-left-color: #d1242f;
+        }
+
+        .markdown-body .markdown-alert-caution .markdown-alert-title {
+            color: #d1242f;
+        }
+
+        .markdown-body math[display="block"] {
+            display: block;
+            overflow-x: auto;
+            overflow-y: hidden;
+            margin-top: 0;
+            margin-bottom: 16px;
+        }
+
+        .markdown-body .math-error code {
+            color: #cf222e;
+            background-color: #ffebe9;
+        }
+
+        .markdown-body .math-error mark {
+            color: #ffffff;
+            background-color: #cf222e;
+        }
+
+        .markdown-body div.math-error {
+            margin-bottom: 16px;
+        }
+
+        .markdown-body .diagram {
+            margin-bottom: 16px;
+            overflow: auto;
+            text-align: center;
+        }
+
+        .markdown-body .diagram svg {
+            max-width: 100%;
+            height: auto;
+        }
+
+        .markdown-body .diagram-warning,
+        .markdown-body .include-warning {
+            padding: 4px 8px;
+            margin-bottom: 4px;
+            font-size: 12px;
+            color: #9a6700;
+            background-color: #fff8c5;
+            border-radius: 6px;
+        }
+
+        .markdown-body .code-block {
+            margin-bottom: 16px;
+        }
+
+        .markdown-body .code-block pre {
+            margin-bottom: 0;
+            border-top-left-radius: 0;
+            border-top-right-radius: 0;
+        }
+
+        .markdown-body .code-title {
+            padding: 6px 16px;
+            font-family: SFMono-Regular, Consolas, "Liberation Mono", Menlo, monospace;
+            font-size: 85%;
+            color: #57606a;
+            background-color: #eaeef2;
+            border-top-left-radius: 6px;
+            border-top-right-radius: 6px;
+        }
+
+        .markdown-body .chroma .hl {
+            margin: 0 -16px;
+            padding: 0 16px;
+            background-color: #fff8c5;
+        }
+
+        .markdown-body .chroma .diff-add {
+            background-color: #e6ffec;
+        }
+
+        .markdown-body .chroma .diff-del {
+            background-color: #ffebe9;
+        }
+
+        .markdown-body .chroma .diff-hunk,
+        .markdown-body .chroma .diff-header {
+            background-color: #ddf4ff;
+        }
+
+        .markdown-body .chroma .diff-hunk *,
+        .markdown-body .chroma .diff-header * {
+            color: #57606a;
+            background-color: transparent;
+        }
+
+        .markdown-body .chroma .diff-marker {
+            display: inline-block;
+            width: 1.5em;
+            color: #6e7781;
+            -webkit-user-select: none;
+            user-select: none;
+        }
+
         @media (max-width: 767px) {
             .markdown-body {
                 padding: 15px;
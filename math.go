@@ -0,0 +1,922 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// mathSpan is a TeX fragment lifted out of the Markdown before parsing so
+// blackfriday does not treat its underscores and asterisks as emphasis.
+type mathSpan struct {
+	TeX     string
+	Display bool
+}
+
+const (
+	mathPlaceholderOpen  = "\uE000"
+	mathPlaceholderClose = "\uE001"
+)
+
+func mathPlaceholder(i int) string {
+	return mathPlaceholderOpen + strconv.Itoa(i) + mathPlaceholderClose
+}
+
+// extractMath replaces $inline$ and $$display$$ math with placeholders and
+// returns the spans in placeholder order. Code blocks and inline code spans
+// are left alone, and line numbers are preserved.
+func extractMath(markdown []byte) ([]byte, []mathSpan) {
+	if !bytes.ContainsRune(markdown, '$') {
+		return markdown, nil
+	}
+
+	var spans []mathSpan
+	var out bytes.Buffer
+	code := newCodeScanner()
+	var display []string
+	scanner := bufio.NewScanner(bytes.NewReader(markdown))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(markdown)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if display != nil {
+			if strings.HasSuffix(trimmed, "$$") {
+				display = append(display, strings.TrimSuffix(trimmed, "$$"))
+				out.WriteString(mathPlaceholder(len(spans)))
+				spans = append(spans, mathSpan{TeX: strings.TrimSpace(strings.Join(display, "\n")), Display: true})
+				out.WriteString(strings.Repeat("\n", len(display)))
+				display = nil
+				continue
+			}
+			display = append(display, line)
+			continue
+		}
+
+		switch {
+		case code.inside(line):
+		case trimmed == "$$" || (strings.HasPrefix(trimmed, "$$") && !strings.Contains(trimmed[2:], "$$")):
+			display = []string{strings.TrimPrefix(trimmed, "$$")}
+			continue
+		default:
+			line = extractInlineMath(line, &spans)
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if display != nil {
+		// Unterminated display math: put the lines back untouched.
+		out.WriteString("$$" + strings.Join(display, "\n") + "\n")
+	}
+	return out.Bytes(), spans
+}
+
+// extractInlineMath follows Pandoc's rules: the opening $ must be followed
+// by a non-space, the closing $ preceded by a non-space and not followed by
+// a digit, so "costs $5 and $10" stays text.
+func extractInlineMath(line string, spans *[]mathSpan) string {
+	if !strings.Contains(line, "$") {
+		return line
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			// Blackfriday does not treat \$ as an escape, so resolve it here.
+			if line[i+1] == '$' {
+				out.WriteByte('$')
+			} else {
+				out.WriteString(line[i : i+2])
+			}
+			i += 2
+			continue
+		case c == '`':
+			n := 0
+			for i+n < len(line) && line[i+n] == '`' {
+				n++
+			}
+			ticks := line[i : i+n]
+			if end := strings.Index(line[i+n:], ticks); end >= 0 {
+				out.WriteString(line[i : i+n+end+n])
+				i += n + end + n
+			} else {
+				out.WriteString(ticks)
+				i += n
+			}
+			continue
+		case c == '$' && strings.HasPrefix(line[i:], "$$"):
+			if end := strings.Index(line[i+2:], "$$"); end > 0 {
+				out.WriteString(mathPlaceholder(len(*spans)))
+				*spans = append(*spans, mathSpan{TeX: strings.TrimSpace(line[i+2 : i+2+end]), Display: true})
+				i += 2 + end + 2
+				continue
+			}
+		case c == '$':
+			if end := closingDollar(line, i+1); end > 0 {
+				out.WriteString(mathPlaceholder(len(*spans)))
+				*spans = append(*spans, mathSpan{TeX: line[i+1 : end]})
+				i = end + 1
+				continue
+			}
+		}
+		out.WriteByte(c)
+		i++
+	}
+	return out.String()
+}
+
+func closingDollar(line string, start int) int {
+	if start >= len(line) || line[start] == ' ' || line[start] == '\t' || line[start] == '$' {
+		return -1
+	}
+	for i := start; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '`':
+			// Math never extends into a code span.
+			return -1
+		case '$':
+			prev := line[i-1]
+			if i == start || prev == ' ' || prev == '\t' {
+				continue
+			}
+			if i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9' {
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// restoreMath swaps the placeholders in the rendered body for MathML.
+// Display math that ended up alone in a paragraph replaces the paragraph.
+// Inside tags, such as the alt text of an image, a placeholder becomes the
+// escaped TeX source instead, as markup is not allowed there.
+func restoreMath(body string, spans []mathSpan) string {
+	if len(spans) == 0 {
+		return body
+	}
+	paragraphs := make([]string, 0, len(spans)*2)
+	text := make([]string, 0, len(spans)*2)
+	source := make([]string, 0, len(spans)*2)
+	for i, span := range spans {
+		rendered := renderMath(span.TeX, span.Display)
+		delimiter := "$"
+		if span.Display {
+			paragraphs = append(paragraphs, "<p>"+mathPlaceholder(i)+"</p>", rendered)
+			delimiter = "$$"
+		}
+		text = append(text, mathPlaceholder(i), rendered)
+		source = append(source, mathPlaceholder(i), template.HTMLEscapeString(delimiter+span.TeX+delimiter))
+	}
+	body = strings.NewReplacer(paragraphs...).Replace(body)
+	inText, inTag := strings.NewReplacer(text...), strings.NewReplacer(source...)
+
+	var out strings.Builder
+	for {
+		start := strings.IndexByte(body, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(body[start:], '>')
+		if end < 0 {
+			break
+		}
+		end += start + 1
+		out.WriteString(inText.Replace(body[:start]))
+		out.WriteString(inTag.Replace(body[start:end]))
+		body = body[end:]
+	}
+	out.WriteString(inText.Replace(body))
+	return out.String()
+}
+
+// fixMathHeadingIDs gives headings holding math the automatic ID of their
+// TeX source. The parser builds IDs from the heading line as it sees it,
+// with math as placeholders, so "# Energy $E=mc^2$" would become
+// "energy-0". markdown is the source the parser was given.
+func fixMathHeadingIDs(markdown []byte, doc *blackfriday.Node, spans []mathSpan) {
+	if len(spans) == 0 {
+		return
+	}
+	ids := map[string]string{}
+	for _, line := range strings.Split(string(markdown), "\n") {
+		if strings.Contains(line, mathPlaceholderOpen) {
+			ids[blackfriday.SanitizedAnchorName(line)] = blackfriday.SanitizedAnchorName(restoreMathSource(line, spans))
+		}
+	}
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering || node.Type != blackfriday.Heading {
+			return blackfriday.GoToNext
+		}
+		if id, ok := ids[node.HeadingID]; ok && containsMath(node) {
+			node.HeadingID = id
+		}
+		return blackfriday.SkipChildren
+	})
+}
+
+// containsMath reports whether the text under node holds a placeholder.
+func containsMath(node *blackfriday.Node) bool {
+	found := false
+	node.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if bytes.Contains(n.Literal, []byte(mathPlaceholderOpen)) {
+			found = true
+			return blackfriday.Terminate
+		}
+		return blackfriday.GoToNext
+	})
+	return found
+}
+
+// renderMath converts TeX to MathML, or to an inline error showing the
+// source with the offending part highlighted.
+func renderMath(tex string, display bool) string {
+	mathml, err := texToMathML(tex, display)
+	if err == nil {
+		return mathml
+	}
+
+	start, end := 0, len(tex)
+	if texErr, ok := err.(*texError); ok {
+		start, end = texErr.Start, texErr.End
+		if end <= start {
+			end = start
+		}
+	}
+	tag := "span"
+	if display {
+		tag = "div"
+	}
+	return fmt.Sprintf(`<%s class="math-error" title="%s"><code>%s<mark>%s</mark>%s</code></%s>`,
+		tag,
+		template.HTMLEscapeString(err.Error()),
+		template.HTMLEscapeString(tex[:start]),
+		template.HTMLEscapeString(tex[start:end]),
+		template.HTMLEscapeString(tex[end:]),
+		tag)
+}
+
+func texToMathML(tex string, display bool) (string, error) {
+	p := &texParser{src: tex, display: display}
+	body, err := p.parseExpr()
+	if err != nil {
+		return "", err
+	}
+	if p.pos < len(p.src) {
+		return "", p.errorf(p.pos, p.pos+1, "unexpected %q", p.src[p.pos:p.pos+1])
+	}
+
+	mode := "inline"
+	if display {
+		mode = "block"
+	}
+	return fmt.Sprintf(`<math xmlns="http://www.w3.org/1998/Math/MathML" display="%s"><semantics><mrow>%s</mrow><annotation encoding="application/x-tex">%s</annotation></semantics></math>`,
+		mode, body, template.HTMLEscapeString(tex)), nil
+}
+
+// texError points at the part of the TeX source that could not be parsed.
+type texError struct {
+	Msg        string
+	Start, End int
+}
+
+func (e *texError) Error() string {
+	return e.Msg
+}
+
+type texParser struct {
+	src     string
+	pos     int
+	display bool
+	variant string // mathvariant applied to tokens, e.g. inside \mathbf
+	depth   int    // environment nesting; "\\" ends a row when > 0
+}
+
+func (p *texParser) errorf(start, end int, format string, args ...interface{}) error {
+	if end > len(p.src) {
+		end = len(p.src)
+	}
+	return &texError{Msg: fmt.Sprintf(format, args...), Start: start, End: end}
+}
+
+func (p *texParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// peekCommand returns the control sequence at the current position without
+// consuming it, or "" if there is none.
+func (p *texParser) peekCommand() string {
+	if p.pos >= len(p.src) || p.src[p.pos] != '\\' {
+		return ""
+	}
+	end := p.pos + 1
+	for end < len(p.src) && isASCIILetter(p.src[end]) {
+		end++
+	}
+	if end == p.pos+1 && end < len(p.src) {
+		end++
+	}
+	return p.src[p.pos+1 : end]
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// atExprEnd reports whether the current expression is terminated by a
+// closing brace, a table separator or an environment/\right boundary.
+func (p *texParser) atExprEnd() bool {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return true
+	}
+	switch p.src[p.pos] {
+	case '}', '&':
+		return true
+	}
+	switch p.peekCommand() {
+	case "right", "end", "\\":
+		return true
+	}
+	return false
+}
+
+func (p *texParser) parseExpr() (string, error) {
+	var out strings.Builder
+	for !p.atExprEnd() {
+		atom, err := p.parseAtom()
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(atom)
+	}
+	// A top-level "\\" outside any environment is a line break.
+	if p.peekCommand() == "\\" && p.display && p.depth == 0 {
+		p.pos += 2
+		rest, err := p.parseExpr()
+		if err != nil {
+			return "", err
+		}
+		return out.String() + `<mspace linebreak="newline"></mspace>` + rest, nil
+	}
+	return out.String(), nil
+}
+
+// parseAtom parses one primary together with any sub-, superscripts and
+// primes that follow it.
+func (p *texParser) parseAtom() (string, error) {
+	start := p.pos
+	base, limits, err := p.parsePrimary()
+	if err != nil {
+		return "", err
+	}
+
+	var sub string
+	var sup []string
+	hasSup := false
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			break
+		}
+		c := p.src[p.pos]
+		if c == '\'' {
+			p.pos++
+			sup = append(sup, "<mo>&#x2032;</mo>")
+			continue
+		}
+		if c != '_' && c != '^' {
+			break
+		}
+		p.pos++
+		if c == '_' && sub != "" {
+			return "", p.errorf(start, p.pos, "double subscript")
+		}
+		if c == '^' && hasSup {
+			return "", p.errorf(start, p.pos, "double superscript")
+		}
+		arg, err := p.parseArgument()
+		if err != nil {
+			return "", err
+		}
+		if c == '_' {
+			sub = arg
+		} else {
+			sup = append(sup, arg)
+			hasSup = true
+		}
+	}
+
+	script := strings.Join(sup, "")
+	if len(sup) > 1 {
+		script = "<mrow>" + script + "</mrow>"
+	}
+	under, over := "msub", "msup"
+	both := "msubsup"
+	if limits && p.display {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case sub != "" && script != "":
+		return fmt.Sprintf("<%s>%s%s%s</%s>", both, base, sub, script, both), nil
+	case sub != "":
+		return fmt.Sprintf("<%s>%s%s</%s>", under, base, sub, under), nil
+	case script != "":
+		return fmt.Sprintf("<%s>%s%s</%s>", over, base, script, over), nil
+	}
+	return base, nil
+}
+
+// parseArgument reads a single-token or braced argument, as used by
+// scripts and commands like \frac.
+func (p *texParser) parseArgument() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return "", p.errorf(p.pos-1, p.pos, "missing argument")
+	}
+	if p.src[p.pos] == '{' {
+		return p.parseGroup()
+	}
+	if p.src[p.pos] == '}' || p.src[p.pos] == '^' || p.src[p.pos] == '_' {
+		return "", p.errorf(p.pos, p.pos+1, "missing argument")
+	}
+	// A bare argument is one character or one control sequence, not a
+	// run of digits: x^10 puts only the 1 in the exponent.
+	if c := p.src[p.pos]; c >= '0' && c <= '9' {
+		p.pos++
+		return p.token("mn", string(c)), nil
+	}
+	atom, _, err := p.parsePrimary()
+	return atom, err
+}
+
+func (p *texParser) parseGroup() (string, error) {
+	open := p.pos
+	p.pos++
+	body, err := p.parseExpr()
+	if err != nil {
+		return "", err
+	}
+	if p.pos >= len(p.src) || p.src[p.pos] != '}' {
+		return "", p.errorf(open, p.pos, "unbalanced braces")
+	}
+	p.pos++
+	return "<mrow>" + body + "</mrow>", nil
+}
+
+// rawGroup returns the text of a braced argument verbatim, for \text and
+// environment names.
+func (p *texParser) rawGroup() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != '{' {
+		return "", p.errorf(p.pos, p.pos+1, "expected {")
+	}
+	open := p.pos
+	depth := 0
+	for i := p.pos; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos = i + 1
+				return p.src[open+1 : i], nil
+			}
+		}
+	}
+	return "", p.errorf(open, len(p.src), "unbalanced braces")
+}
+
+func (p *texParser) token(tag, text string) string {
+	attrs := ""
+	if p.variant != "" && tag != "mo" {
+		attrs = fmt.Sprintf(` mathvariant="%s"`, p.variant)
+	} else if tag == "mi" && len([]rune(text)) > 1 {
+		attrs = ` mathvariant="normal"`
+	}
+	return fmt.Sprintf("<%s%s>%s</%s>", tag, attrs, template.HTMLEscapeString(text), tag)
+}
+
+var texOperatorChars = map[byte]string{
+	'+': "+", '-': "−", '=': "=", '<': "<", '>': ">", '(': "(", ')': ")",
+	'[': "[", ']': "]", '|': "|", ',': ",", ';': ";", ':': ":", '!': "!",
+	'/': "/", '*': "∗", '.': ".", '?': "?",
+}
+
+// parsePrimary parses a single token, group or command. limits reports
+// whether scripts attach as under/over limits in display mode.
+func (p *texParser) parsePrimary() (out string, limits bool, err error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return "", false, p.errorf(p.pos, p.pos, "unexpected end of input")
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '{':
+		out, err = p.parseGroup()
+		return out, false, err
+	case c == '}':
+		return "", false, p.errorf(p.pos, p.pos+1, "unbalanced braces")
+	case c == '^' || c == '_':
+		// Scripts with no base attach to an empty row.
+		return "<mrow></mrow>", false, nil
+	case c == '\\':
+		return p.parseCommand()
+	case c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.' && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9') {
+			p.pos++
+		}
+		return p.token("mn", p.src[start:p.pos]), false, nil
+	case isASCIILetter(c):
+		p.pos++
+		return p.token("mi", string(c)), false, nil
+	}
+	if op, ok := texOperatorChars[c]; ok {
+		p.pos++
+		return p.token("mo", op), false, nil
+	}
+	if c == '~' {
+		p.pos++
+		return `<mspace width="0.333em"></mspace>`, false, nil
+	}
+	if c == '#' || c == '%' || c == '&' {
+		return "", false, p.errorf(p.pos, p.pos+1, "unexpected %q", string(c))
+	}
+	// Any other (non-ASCII) character is passed through as an identifier.
+	r := []rune(p.src[p.pos:])[0]
+	p.pos += len(string(r))
+	return p.token("mi", string(r)), false, nil
+}
+
+var texIdentifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ",
+	"varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ",
+	"varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "emptyset": "∅", "varnothing": "∅",
+	"hbar": "ℏ", "ell": "ℓ", "Re": "ℜ", "Im": "ℑ", "aleph": "ℵ", "wp": "℘",
+	"prime": "′", "angle": "∠", "triangle": "△", "top": "⊤", "bot": "⊥",
+}
+
+var texOperators = map[string]string{
+	"cdot": "⋅", "times": "×", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗", "star": "⋆",
+	"circ": "∘", "bullet": "∙", "oplus": "⊕", "otimes": "⊗", "odot": "⊙",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "ll": "≪", "gg": "≫",
+	"approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹", "iff": "⟺",
+	"mapsto": "↦", "longrightarrow": "⟶", "longleftarrow": "⟵", "uparrow": "↑", "downarrow": "↓",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆", "supset": "⊃",
+	"supseteq": "⊇", "cup": "∪", "cap": "∩", "setminus": "∖", "land": "∧", "wedge": "∧",
+	"lor": "∨", "vee": "∨", "neg": "¬", "lnot": "¬", "forall": "∀", "exists": "∃",
+	"nexists": "∄", "mid": "∣", "parallel": "∥", "perp": "⊥", "vdash": "⊢", "models": "⊨",
+	"ldots": "…", "dots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+	"lbrace": "{", "rbrace": "}", "vert": "|", "Vert": "‖", "|": "‖",
+	"{": "{", "}": "}", "colon": ":",
+}
+
+var texLargeOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "bigcup": "⋃", "bigcap": "⋂",
+	"bigoplus": "⨁", "bigotimes": "⨂", "bigvee": "⋁", "bigwedge": "⋀",
+}
+
+var texIntegrals = map[string]string{
+	"int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+}
+
+var texFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true,
+	"arcsin": true, "arccos": true, "arctan": true, "sinh": true, "cosh": true, "tanh": true,
+	"log": true, "ln": true, "lg": true, "exp": true, "det": true, "dim": true, "ker": true,
+	"gcd": true, "deg": true, "arg": true, "hom": true, "Pr": true,
+}
+
+// texLimitFunctions take their subscripts underneath in display mode.
+var texLimitFunctions = map[string]bool{
+	"lim": true, "liminf": true, "limsup": true, "max": true, "min": true, "sup": true, "inf": true,
+	"argmax": true, "argmin": true,
+}
+
+var texSpaces = map[string]string{
+	",": "0.167em", ":": "0.222em", ">": "0.222em", ";": "0.278em", " ": "0.333em",
+	"!": "-0.167em", "quad": "1em", "qquad": "2em",
+}
+
+var texVariants = map[string]string{
+	"mathbf": "bold", "boldsymbol": "bold-italic", "mathit": "italic", "mathrm": "normal",
+	"mathbb": "double-struck", "mathcal": "script", "mathfrak": "fraktur", "mathsf": "sans-serif",
+	"mathtt": "monospace",
+}
+
+var texAccents = map[string]struct {
+	Mark    string
+	Under   bool
+	Stretch bool
+}{
+	"hat": {"^", false, false}, "widehat": {"^", false, true}, "bar": {"¯", false, false},
+	"overline": {"‾", false, true}, "underline": {"_", true, true}, "vec": {"→", false, false},
+	"overrightarrow": {"→", false, true}, "dot": {"˙", false, false}, "ddot": {"¨", false, false},
+	"tilde": {"~", false, false}, "widetilde": {"~", false, true},
+	"overbrace": {"⏞", false, true}, "underbrace": {"⏟", true, true},
+}
+
+// texEscapes are the single-character control sequences for TeX specials.
+var texEscapes = map[string]string{
+	"$": "$", "%": "%", "&": "&", "#": "#", "_": "_",
+}
+
+func (p *texParser) parseCommand() (string, bool, error) {
+	start := p.pos
+	name := p.peekCommand()
+	if name == "" {
+		return "", false, p.errorf(start, start+1, "stray backslash")
+	}
+	p.pos += 1 + len(name)
+
+	if s, ok := texIdentifiers[name]; ok {
+		return p.token("mi", s), false, nil
+	}
+	if s, ok := texOperators[name]; ok {
+		return p.token("mo", s), false, nil
+	}
+	if s, ok := texLargeOperators[name]; ok {
+		return fmt.Sprintf(`<mo largeop="true" movablelimits="true">%s</mo>`, s), true, nil
+	}
+	if s, ok := texIntegrals[name]; ok {
+		return fmt.Sprintf(`<mo largeop="true">%s</mo>`, s), false, nil
+	}
+	if texFunctions[name] {
+		return p.token("mi", name), false, nil
+	}
+	if texLimitFunctions[name] {
+		text := name
+		switch name {
+		case "liminf":
+			text = "lim inf"
+		case "limsup":
+			text = "lim sup"
+		case "argmax":
+			text = "arg max"
+		case "argmin":
+			text = "arg min"
+		}
+		return fmt.Sprintf(`<mo movablelimits="true" form="prefix">%s</mo>`, text), true, nil
+	}
+	if width, ok := texSpaces[name]; ok {
+		return fmt.Sprintf(`<mspace width="%s"></mspace>`, width), false, nil
+	}
+	if s, ok := texEscapes[name]; ok {
+		return p.token("mi", s), false, nil
+	}
+	if variant, ok := texVariants[name]; ok {
+		saved := p.variant
+		p.variant = variant
+		arg, err := p.parseArgument()
+		p.variant = saved
+		return arg, false, err
+	}
+	if accent, ok := texAccents[name]; ok {
+		arg, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		tag, attr := "mover", "accent"
+		if accent.Under {
+			tag, attr = "munder", "accentunder"
+		}
+		return fmt.Sprintf(`<%s %s="true">%s<mo stretchy="%t">%s</mo></%s>`, tag, attr, arg, accent.Stretch, accent.Mark, tag), false, nil
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac":
+		num, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		den, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf("<mfrac>%s%s</mfrac>", num, den), false, nil
+	case "binom":
+		top, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		bottom, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf(`<mrow><mo>(</mo><mfrac linethickness="0">%s%s</mfrac><mo>)</mo></mrow>`, top, bottom), false, nil
+	case "sqrt":
+		p.skipSpace()
+		index := ""
+		if p.pos < len(p.src) && p.src[p.pos] == '[' {
+			end := strings.IndexByte(p.src[p.pos:], ']')
+			if end < 0 {
+				return "", false, p.errorf(p.pos, len(p.src), "missing ] in \\sqrt")
+			}
+			sub := &texParser{src: p.src[p.pos+1 : p.pos+end], display: p.display}
+			idx, err := sub.parseExpr()
+			if err != nil {
+				e := err.(*texError)
+				return "", false, p.errorf(p.pos+1+e.Start, p.pos+1+e.End, "%s", e.Msg)
+			}
+			index = "<mrow>" + idx + "</mrow>"
+			p.pos += end + 1
+		}
+		arg, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		if index != "" {
+			return fmt.Sprintf("<mroot>%s%s</mroot>", arg, index), false, nil
+		}
+		return fmt.Sprintf("<msqrt>%s</msqrt>", arg), false, nil
+	case "text", "textrm", "textbf", "textit", "mbox", "operatorname":
+		text, err := p.rawGroup()
+		if err != nil {
+			return "", false, err
+		}
+		if name == "operatorname" {
+			return fmt.Sprintf(`<mi mathvariant="normal">%s</mi>`, template.HTMLEscapeString(text)), false, nil
+		}
+		return fmt.Sprintf("<mtext>%s</mtext>", template.HTMLEscapeString(text)), false, nil
+	case "left":
+		return p.parseLeftRight(start)
+	case "begin":
+		return p.parseEnvironment(start)
+	case "limits", "nolimits", "displaystyle", "textstyle", "nonumber", "notag":
+		return "", false, nil
+	case "right", "end":
+		return "", false, p.errorf(start, p.pos, "\\%s without matching \\%s", name, map[string]string{"right": "left", "end": "begin"}[name])
+	}
+	return "", false, p.errorf(start, p.pos, "unknown command \\%s", name)
+}
+
+// parseDelimiter reads the delimiter after \left or \right; "." means none.
+func (p *texParser) parseDelimiter(start int) (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return "", p.errorf(start, p.pos, "missing delimiter")
+	}
+	if p.src[p.pos] == '.' {
+		p.pos++
+		return "", nil
+	}
+	if cmd := p.peekCommand(); cmd != "" {
+		if s, ok := texOperators[cmd]; ok {
+			p.pos += 1 + len(cmd)
+			return s, nil
+		}
+		return "", p.errorf(p.pos, p.pos+1+len(cmd), "invalid delimiter \\%s", cmd)
+	}
+	c := p.src[p.pos]
+	if strings.IndexByte("()[]|/", c) < 0 {
+		return "", p.errorf(p.pos, p.pos+1, "invalid delimiter %q", string(c))
+	}
+	p.pos++
+	return string(c), nil
+}
+
+func (p *texParser) parseLeftRight(start int) (string, bool, error) {
+	open, err := p.parseDelimiter(start)
+	if err != nil {
+		return "", false, err
+	}
+	body, err := p.parseExpr()
+	if err != nil {
+		return "", false, err
+	}
+	if p.peekCommand() != "right" {
+		return "", false, p.errorf(start, p.pos, "\\left without matching \\right")
+	}
+	rightStart := p.pos
+	p.pos += len(`\right`)
+	closing, err := p.parseDelimiter(rightStart)
+	if err != nil {
+		return "", false, err
+	}
+
+	var out strings.Builder
+	out.WriteString("<mrow>")
+	if open != "" {
+		fmt.Fprintf(&out, `<mo fence="true" stretchy="true">%s</mo>`, template.HTMLEscapeString(open))
+	}
+	out.WriteString(body)
+	if closing != "" {
+		fmt.Fprintf(&out, `<mo fence="true" stretchy="true">%s</mo>`, template.HTMLEscapeString(closing))
+	}
+	out.WriteString("</mrow>")
+	return out.String(), false, nil
+}
+
+// texEnvironments maps matrix-like environments to their fences.
+var texEnvironments = map[string][2]string{
+	"matrix": {"", ""}, "smallmatrix": {"", ""}, "pmatrix": {"(", ")"}, "bmatrix": {"[", "]"},
+	"Bmatrix": {"{", "}"}, "vmatrix": {"|", "|"}, "Vmatrix": {"‖", "‖"}, "cases": {"{", ""},
+	"aligned": {"", ""}, "align": {"", ""}, "align*": {"", ""}, "gathered": {"", ""},
+	"array": {"", ""}, "split": {"", ""},
+}
+
+func (p *texParser) parseEnvironment(start int) (string, bool, error) {
+	name, err := p.rawGroup()
+	if err != nil {
+		return "", false, err
+	}
+	fences, ok := texEnvironments[name]
+	if !ok {
+		return "", false, p.errorf(start, p.pos, "unknown environment %q", name)
+	}
+	if name == "array" {
+		// Column spec such as {cc|l}; alignment is not reproduced.
+		if _, err := p.rawGroup(); err != nil {
+			return "", false, err
+		}
+	}
+
+	p.depth++
+	defer func() { p.depth-- }()
+
+	var rows []string
+	var cells []string
+	for {
+		cell, err := p.parseExpr()
+		if err != nil {
+			return "", false, err
+		}
+		cells = append(cells, "<mtd>"+cell+"</mtd>")
+		if p.pos >= len(p.src) {
+			return "", false, p.errorf(start, p.pos, "missing \\end{%s}", name)
+		}
+		if p.src[p.pos] == '&' {
+			p.pos++
+			continue
+		}
+		cmd := p.peekCommand()
+		if cmd == "\\" {
+			p.pos += 2
+			rows = append(rows, "<mtr>"+strings.Join(cells, "")+"</mtr>")
+			cells = nil
+			continue
+		}
+		if cmd == "end" {
+			endStart := p.pos
+			p.pos += len(`\end`)
+			endName, err := p.rawGroup()
+			if err != nil {
+				return "", false, err
+			}
+			if endName != name {
+				return "", false, p.errorf(endStart, p.pos, "\\begin{%s} ended by \\end{%s}", name, endName)
+			}
+			break
+		}
+		return "", false, p.errorf(p.pos, p.pos+1, "unexpected %q in %s", p.src[p.pos:p.pos+1], name)
+	}
+	if len(cells) > 1 || cells[0] != "<mtd></mtd>" {
+		rows = append(rows, "<mtr>"+strings.Join(cells, "")+"</mtr>")
+	}
+
+	align := ""
+	switch name {
+	case "cases":
+		align = ` columnalign="left left"`
+	case "aligned", "align", "align*", "split":
+		align = ` columnalign="right left right left"`
+	}
+	table := fmt.Sprintf("<mtable%s>%s</mtable>", align, strings.Join(rows, ""))
+	if fences[0] == "" && fences[1] == "" {
+		return table, false, nil
+	}
+	out := "<mrow>"
+	if fences[0] != "" {
+		out += fmt.Sprintf(`<mo fence="true" stretchy="true">%s</mo>`, fences[0])
+	}
+	out += table
+	if fences[1] != "" {
+		out += fmt.Sprintf(`<mo fence="true" stretchy="true">%s</mo>`, fences[1])
+	}
+	return out + "</mrow>", false, nil
+}
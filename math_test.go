@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMathSkipsCode(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.Extensions = ExtAll
+
+	for _, source := range []string{
+		"Example:\n\n    cost $x$ here\n",
+		"````markdown\nStart a block with ```go\n```\n\n$x$\n````\n",
+		"Inline `$x$` code.\n",
+	} {
+		html := convertMarkdownToHTMLBody([]byte(source))
+		if strings.Contains(html, "<math") {
+			t.Errorf("math rendered inside code for %q:\n%s", source, html)
+		}
+		if !strings.Contains(html, "$x$") {
+			t.Errorf("TeX source missing for %q:\n%s", source, html)
+		}
+	}
+
+	html := convertMarkdownToHTMLBody([]byte("Text\n    $x$ continues the paragraph.\n"))
+	if !strings.Contains(html, "<math") {
+		t.Errorf("math in an indented paragraph line not rendered:\n%s", html)
+	}
+}
+
+func TestMathInAttributes(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.Extensions = ExtAll
+
+	for _, safe := range []bool{false, true} {
+		options.Safe = safe
+		html := convertMarkdownToHTMLBody([]byte("![alt $q<1$](a.png \"title $x$\")\n"))
+		if !strings.Contains(html, `alt="alt $q&lt;1$"`) || !strings.Contains(html, `title="title $x$"`) {
+			t.Errorf("safe=%v: TeX source not restored in attributes:\n%s", safe, html)
+		}
+		if strings.Contains(html, "<math") || strings.Contains(html, mathPlaceholderOpen) {
+			t.Errorf("safe=%v: math left in attributes:\n%s", safe, html)
+		}
+	}
+}
+
+func TestMathHeadingIDs(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.Extensions = ExtAll
+
+	source := "# Energy $E=mc^2$\n\nMass $m$\n---------\n\n# Energy $E=mc^2$\n\n## Step 0\n"
+	html := convertMarkdownToHTMLBody([]byte(source))
+	for _, id := range []string{"energy-e-mc-2", "mass-m", "energy-e-mc-2-1", "step-0"} {
+		if !strings.Contains(html, `id="`+id+`"`) {
+			t.Errorf("missing heading ID %q:\n%s", id, html)
+		}
+	}
+	if !strings.Contains(html, "<math") {
+		t.Errorf("heading math not rendered:\n%s", html)
+	}
+
+	anchors := documentAnchors("doc.md", []byte(source))
+	if !anchors["energy-e-mc-2"] || !anchors["mass-m"] {
+		t.Errorf("check anchors = %v", anchors)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+// SanitizePolicy is the allowlist applied to rendered HTML in --safe mode.
+// Attributes are listed per tag, with "*" holding the ones allowed on every
+// tag; a trailing "*" in a name (e.g. "data-*") matches any suffix.
+type SanitizePolicy struct {
+	Tags       []string            `yaml:"tags"`
+	Attributes map[string][]string `yaml:"attributes"`
+	URLSchemes []string            `yaml:"url_schemes"`
+}
+
+// defaultSanitizePolicy allows everything the renderer itself produces,
+// including MathML and the SVG from diagrams and alert icons, but no
+// scripts, frames, forms or event handlers, and no styles beyond the
+// scoped ones kept by svgStyle.
+func defaultSanitizePolicy() SanitizePolicy {
+	return SanitizePolicy{
+		Tags: []string{
+			"a", "abbr", "b", "blockquote", "br", "caption", "cite", "code", "col", "colgroup",
+			"dd", "del", "details", "dfn", "div", "dl", "dt", "em", "figcaption", "figure",
+			"h1", "h2", "h3", "h4", "h5", "h6", "hr", "i", "img", "input", "ins", "kbd", "li",
+			"mark", "ol", "p", "pre", "q", "s", "samp", "small", "span", "strong", "sub",
+			"summary", "sup", "table", "tbody", "td", "tfoot", "th", "thead", "tr", "tt", "u",
+			"ul", "var", "wbr",
+			// MathML
+			"math", "semantics", "annotation", "mrow", "mi", "mn", "mo", "mtext", "mspace",
+			"msup", "msub", "msubsup", "munder", "mover", "munderover", "mfrac", "msqrt",
+			"mroot", "mtable", "mtr", "mtd", "mstyle", "mpadded", "mphantom",
+			// SVG
+			"svg", "g", "path", "circle", "ellipse", "line", "polygon", "polyline", "rect",
+			"text", "tspan", "title", "desc", "defs", "marker", "use", "symbol",
+			"lineargradient", "radialgradient", "stop", "clippath",
+		},
+		Attributes: map[string][]string{
+			"*":          {"class", "id", "title", "lang", "dir", "role", "aria-*", "data-*"},
+			"a":          {"href", "name", "rel"},
+			"img":        {"src", "alt", "width", "height", "loading"},
+			"input":      {"type", "checked", "disabled"},
+			"ol":         {"start", "type", "reversed"},
+			"li":         {"value"},
+			"td":         {"align", "colspan", "rowspan"},
+			"th":         {"align", "colspan", "rowspan", "scope"},
+			"col":        {"span"},
+			"details":    {"open"},
+			"q":          {"cite"},
+			"blockquote": {"cite"},
+			"math":       {"xmlns", "display"},
+			"mi":         {"mathvariant"},
+			"mn":         {"mathvariant"},
+			"mo":         {"largeop", "movablelimits", "form", "fence", "stretchy"},
+			"mfrac":      {"linethickness"},
+			"mover":      {"accent"},
+			"munder":     {"accentunder"},
+			"mspace":     {"width", "linebreak"},
+			"mtable":     {"columnalign"},
+			"annotation": {"encoding"},
+			"svg":        {"xmlns", "xmlns:xlink", "viewbox", "width", "height", "preserveaspectratio", "fill", "stroke", "version"},
+			"g":          {"transform", "fill", "stroke", "stroke-width", "opacity", "font-family", "font-size"},
+			"path": {"d", "fill", "stroke", "stroke-width", "stroke-dasharray", "stroke-linecap", "stroke-linejoin",
+				"transform", "marker-end", "marker-start", "opacity", "fill-opacity", "stroke-opacity"},
+			"circle":   {"cx", "cy", "r", "fill", "stroke", "stroke-width", "transform"},
+			"ellipse":  {"cx", "cy", "rx", "ry", "fill", "stroke", "stroke-width", "transform"},
+			"line":     {"x1", "y1", "x2", "y2", "stroke", "stroke-width", "stroke-dasharray", "transform", "marker-end", "marker-start"},
+			"polygon":  {"points", "fill", "stroke", "stroke-width", "transform"},
+			"polyline": {"points", "fill", "stroke", "stroke-width", "transform"},
+			"rect":     {"x", "y", "width", "height", "rx", "ry", "fill", "stroke", "stroke-width", "transform"},
+			"text": {"x", "y", "dx", "dy", "text-anchor", "dominant-baseline", "font-family", "font-size",
+				"font-weight", "font-style", "fill", "stroke", "transform"},
+			"tspan":          {"x", "y", "dx", "dy", "text-anchor", "font-family", "font-size", "font-weight", "font-style", "fill"},
+			"marker":         {"viewbox", "refx", "refy", "markerwidth", "markerheight", "markerunits", "orient"},
+			"use":            {"href", "xlink:href", "x", "y", "width", "height"},
+			"symbol":         {"viewbox"},
+			"lineargradient": {"x1", "y1", "x2", "y2", "gradientunits", "gradienttransform"},
+			"radialgradient": {"cx", "cy", "r", "fx", "fy", "gradientunits", "gradienttransform"},
+			"stop":           {"offset", "stop-color", "stop-opacity"},
+			"clippath":       {"clippathunits"},
+		},
+		URLSchemes: []string{"http", "https", "mailto", "tel", "ftp"},
+	}
+}
+
+// loadSanitizePolicy reads a YAML policy file. Sections present in the file
+// replace the corresponding default section; missing ones keep defaults.
+func loadSanitizePolicy(path string) (SanitizePolicy, error) {
+	policy := defaultSanitizePolicy()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return policy, err
+	}
+	var custom SanitizePolicy
+	if err := yaml.Unmarshal(data, &custom); err != nil {
+		return policy, fmt.Errorf("%s: %v", path, err)
+	}
+	if custom.Tags != nil {
+		policy.Tags = custom.Tags
+	}
+	if custom.Attributes != nil {
+		policy.Attributes = custom.Attributes
+	}
+	if custom.URLSchemes != nil {
+		policy.URLSchemes = custom.URLSchemes
+	}
+	return policy, nil
+}
+
+// urlAttributes hold URLs and are checked against the allowed schemes.
+var urlAttributes = map[string]bool{
+	"href": true, "src": true, "cite": true, "xlink:href": true, "action": true, "poster": true,
+}
+
+// droppedContent lists elements whose content is removed along with the
+// tags, rather than being kept as text.
+var droppedContent = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "template": true,
+	"noscript": true, "textarea": true, "select": true, "frameset": true, "noembed": true,
+}
+
+type sanitizer struct {
+	tags    map[string]bool
+	attrs   map[string][]string
+	schemes map[string]bool
+}
+
+func newSanitizer(policy SanitizePolicy) *sanitizer {
+	s := &sanitizer{
+		tags:    map[string]bool{},
+		attrs:   map[string][]string{},
+		schemes: map[string]bool{},
+	}
+	for _, tag := range policy.Tags {
+		s.tags[strings.ToLower(tag)] = true
+	}
+	for tag, attrs := range policy.Attributes {
+		for _, attr := range attrs {
+			s.attrs[strings.ToLower(tag)] = append(s.attrs[strings.ToLower(tag)], strings.ToLower(attr))
+		}
+	}
+	for _, scheme := range policy.URLSchemes {
+		s.schemes[strings.ToLower(scheme)] = true
+	}
+	return s
+}
+
+func (s *sanitizer) attrAllowed(tag, attr string) bool {
+	for _, list := range [][]string{s.attrs[tag], s.attrs["*"]} {
+		for _, allowed := range list {
+			if allowed == attr || (strings.HasSuffix(allowed, "*") && strings.HasPrefix(attr, allowed[:len(allowed)-1])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// urlAllowed accepts relative URLs and fragments, absolute URLs with an
+// allowed scheme, and data: URIs for images.
+func (s *sanitizer) urlAllowed(tag, value string) bool {
+	value = strings.TrimSpace(value)
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" {
+		// Reject things like "java\tscript:" that url.Parse reads as a path.
+		return !strings.Contains(strings.ToLower(strings.Map(dropControl, value)), "script:")
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme == "data" {
+		return tag == "img" && strings.HasPrefix(strings.ToLower(u.Opaque), "image/")
+	}
+	return s.schemes[scheme]
+}
+
+func dropControl(r rune) rune {
+	if r <= ' ' {
+		return -1
+	}
+	return r
+}
+
+// sanitizeHTML removes every tag, attribute and URL not allowed by policy.
+// Text is re-escaped, comments and doctypes are dropped, and the content of
+// script-like elements is removed entirely.
+func sanitizeHTML(body string, policy SanitizePolicy) string {
+	s := newSanitizer(policy)
+	var out bytes.Buffer
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	skipping := ""
+	skipDepth := 0
+	var svgIDs []string // ids of the open <svg> elements
+
+	for {
+		tt := tokenizer.Next()
+		if tt == html.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				break
+			}
+			return out.String()
+		}
+		token := tokenizer.Token()
+
+		if skipping != "" {
+			switch {
+			case tt == html.StartTagToken && token.Data == skipping:
+				skipDepth++
+			case tt == html.EndTagToken && token.Data == skipping:
+				skipDepth--
+				if skipDepth == 0 {
+					skipping = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			out.WriteString(html.EscapeString(token.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if token.Data == "style" && tt == html.StartTagToken && len(svgIDs) > 0 {
+				// The tokenizer reads a style element's content as one
+				// text token, whatever it looks like.
+				var css string
+				if tokenizer.Next() == html.TextToken {
+					css = html.UnescapeString(string(tokenizer.Text()))
+					tokenizer.Next()
+				}
+				if rules := svgStyle(svgIDs[len(svgIDs)-1], css); rules != "" {
+					out.WriteString("<style>" + html.EscapeString(rules) + "</style>")
+				}
+				continue
+			}
+			if droppedContent[token.Data] {
+				if tt == html.StartTagToken {
+					skipping, skipDepth = token.Data, 1
+				}
+				continue
+			}
+			if !s.tags[token.Data] {
+				continue
+			}
+			if token.Data == "input" && !isCheckbox(token) {
+				continue
+			}
+			s.writeTag(&out, token, tt == html.SelfClosingTagToken)
+			if token.Data == "svg" && tt == html.StartTagToken {
+				id := ""
+				if s.attrAllowed("svg", "id") {
+					id = attrValue(token, "id")
+				}
+				svgIDs = append(svgIDs, id)
+			}
+		case html.EndTagToken:
+			if token.Data == "svg" && s.tags["svg"] && len(svgIDs) > 0 {
+				svgIDs = svgIDs[:len(svgIDs)-1]
+			}
+			if s.tags[token.Data] {
+				fmt.Fprintf(&out, "</%s>", token.Data)
+			}
+		}
+	}
+	return out.String()
+}
+
+func isCheckbox(token html.Token) bool {
+	return strings.EqualFold(attrValue(token, "type"), "checkbox")
+}
+
+func attrValue(token html.Token, key string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// unsafeCSS matches declarations that could load something or run code.
+var unsafeCSS = regexp.MustCompile(`(?i)url\(|image-set|@import|expression|binding|behavior|\\|[<{]`)
+
+// svgStyle keeps the rules of a <style> inside the <svg> with the given id
+// whose selectors all start at that element, so they cannot style the rest
+// of the page, and whose declarations load nothing. This is how mermaid
+// styles its diagrams. At-rules such as @keyframes are dropped.
+func svgStyle(id, css string) string {
+	if id == "" {
+		return ""
+	}
+	scope := "#" + id
+	var b strings.Builder
+	for {
+		open := strings.IndexByte(css, '{')
+		if open < 0 {
+			break
+		}
+		depth, end := 0, -1
+		for i := open; i < len(css) && end < 0; i++ {
+			switch css[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth--; depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			break
+		}
+		selectors, declarations := strings.TrimSpace(css[:open]), css[open+1:end]
+		css = css[end+1:]
+		if scopedSelectors(scope, selectors) && !unsafeCSS.MatchString(declarations) {
+			b.WriteString(selectors + "{" + declarations + "}")
+		}
+	}
+	return b.String()
+}
+
+// scopedSelectors reports whether every selector in the list starts at the
+// element matched by scope and only reaches down into it.
+func scopedSelectors(scope, selectors string) bool {
+	for _, selector := range strings.Split(selectors, ",") {
+		selector = strings.TrimSpace(selector)
+		rest, ok := strings.CutPrefix(selector, scope)
+		if !ok || strings.ContainsAny(rest, "~+\\<") {
+			return false
+		}
+		if rest != "" && !strings.ContainsRune(" >.:[", rune(rest[0])) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *sanitizer) writeTag(out *bytes.Buffer, token html.Token, selfClosing bool) {
+	out.WriteString("<" + token.Data)
+	for _, attr := range token.Attr {
+		key := attr.Key
+		if attr.Namespace != "" {
+			key = attr.Namespace + ":" + key
+		}
+		if strings.HasPrefix(key, "on") || !s.attrAllowed(token.Data, key) {
+			continue
+		}
+		if urlAttributes[key] && !s.urlAllowed(token.Data, attr.Val) {
+			continue
+		}
+		fmt.Fprintf(out, ` %s="%s"`, key, html.EscapeString(attr.Val))
+	}
+	if selfClosing {
+		out.WriteString(" /")
+	}
+	out.WriteString(">")
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// searchEntry is one page of the site search index. Keys are kept short
+// because the index is loaded by every page.
+type searchEntry struct {
+	Title    string          `json:"t"`
+	URL      string          `json:"u"`
+	Headings []searchHeading `json:"h,omitempty"`
+	Text     string          `json:"b"`
+}
+
+type searchHeading struct {
+	Text string `json:"t"`
+	ID   string `json:"i,omitempty"`
+}
+
+// searchEntryFor collects the headings and text of a parsed document.
+// Code blocks, images and raw HTML are left out; inline code and math source are
+// kept so they can be searched for.
+func searchEntryFor(renderer *CustomHTMLRenderer, doc *blackfriday.Node, title, url string) searchEntry {
+	entry := searchEntry{Title: title, URL: url}
+	var body, heading strings.Builder
+	var inHeading *blackfriday.Node
+
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		switch node.Type {
+		case blackfriday.CodeBlock, blackfriday.HTMLBlock, blackfriday.HTMLSpan, blackfriday.Image:
+			return blackfriday.SkipChildren
+		case blackfriday.Heading:
+			if entering {
+				inHeading = node
+				heading.Reset()
+			} else {
+				text := strings.TrimSpace(heading.String())
+				if node.Level > 1 || text != title {
+					entry.Headings = append(entry.Headings, searchHeading{Text: text, ID: node.HeadingID})
+				}
+				inHeading = nil
+			}
+		case blackfriday.Text, blackfriday.Code:
+			if !entering {
+				break
+			}
+			text := restoreMathSource(string(node.Literal), renderer.math)
+			if inHeading != nil {
+				heading.WriteString(text)
+			} else {
+				body.WriteString(text)
+			}
+		case blackfriday.Paragraph, blackfriday.Item, blackfriday.TableCell, blackfriday.Softbreak, blackfriday.Hardbreak:
+			if !entering && inHeading == nil {
+				body.WriteString(" ")
+			}
+		}
+		return blackfriday.GoToNext
+	})
+
+	entry.Text = strings.Join(strings.Fields(body.String()), " ")
+	return entry
+}
+
+// restoreMathSource puts the TeX source back in place of math placeholders.
+func restoreMathSource(text string, spans []mathSpan) string {
+	if !strings.Contains(text, mathPlaceholderOpen) {
+		return text
+	}
+	pairs := make([]string, 0, len(spans)*2)
+	for i, span := range spans {
+		pairs = append(pairs, mathPlaceholder(i), span.TeX)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// writeSearchIndex writes the index as a script assigning the JSON to a
+// global, so pages opened from disk can load it without a server: browsers
+// refuse to fetch JSON from file:// URLs, but do run scripts.
+func writeSearchIndex(file string, entries []searchEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	script := "var mdreaderSearchIndex = " + string(data) + ";\n"
+	return os.WriteFile(file, []byte(script), 0644)
+}
+
+// searchBox is the search field placed in the sidebar.
+const searchBox = `<div class="site-search">
+            <input type="search" class="site-search-input" placeholder="Search" aria-label="Search the documentation" autocomplete="off">
+            <ul class="site-search-results" hidden></ul>
+        </div>`
+
+// getSearchScript returns the script that loads the index from root and
+// runs the search box. Every query word must prefix-match a word of the
+// page; title and heading matches rank first.
+func getSearchScript(root string) string {
+	return fmt.Sprintf(`<script src="%ssearch-index.js"></script>
+<script>
+(function () {
+    var root = %q;
+    var input = document.querySelector('.site-search-input');
+    var results = document.querySelector('.site-search-results');
+    if (!input || typeof mdreaderSearchIndex === 'undefined') return;
+
+    function words(text) {
+        return text.toLowerCase().split(/[^\p{L}\p{N}]+/u).filter(Boolean);
+    }
+
+    var pages = mdreaderSearchIndex.map(function (page) {
+        var headings = page.h || [];
+        return {
+            page: page,
+            title: words(page.t),
+            headings: headings.map(function (h) { return words(h.t); }),
+            body: words(page.b)
+        };
+    });
+
+    function matches(list, term) {
+        for (var i = 0; i < list.length; i++) {
+            if (list[i].indexOf(term) === 0) return true;
+        }
+        return false;
+    }
+
+    function escapeHTML(text) {
+        return text.replace(/[&<>"']/g, function (c) {
+            return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[c];
+        });
+    }
+
+    function escapeRegExp(text) {
+        return text.replace(/[.*+?^${}()|[\]\\]/g, '\\$&');
+    }
+
+    // highlight marks the words starting with a query term
+    function highlight(text, terms) {
+        var pattern = new RegExp('(^|[^\\p{L}\\p{N}])(' + terms.map(escapeRegExp).join('|') + ')', 'giu');
+        var out = '';
+        var last = 0;
+        var m;
+        while ((m = pattern.exec(text)) !== null) {
+            var start = m.index + m[1].length;
+            out += escapeHTML(text.slice(last, start)) + '<mark>' + escapeHTML(m[2]) + '</mark>';
+            last = start + m[2].length;
+        }
+        return out + escapeHTML(text.slice(last));
+    }
+
+    function snippet(text, terms) {
+        var lower = text.toLowerCase();
+        var at = -1;
+        terms.forEach(function (t) {
+            var i = lower.search(new RegExp('(^|[^\\p{L}\\p{N}])' + escapeRegExp(t), 'u'));
+            if (i >= 0 && (at < 0 || i < at)) at = i;
+        });
+        if (at < 0) return text.slice(0, 140);
+        var start = Math.max(0, at - 50);
+        var out = text.slice(start, start + 160);
+        return (start > 0 ? '… ' : '') + out + (start + 160 < text.length ? ' …' : '');
+    }
+
+    function search(query) {
+        var terms = words(query);
+        if (terms.length === 0) return [];
+        var found = [];
+        pages.forEach(function (p) {
+            var score = 0;
+            var heading = null;
+            for (var i = 0; i < terms.length; i++) {
+                var term = terms[i];
+                var s = 0;
+                if (matches(p.title, term)) s += 10;
+                for (var h = 0; h < p.headings.length; h++) {
+                    if (matches(p.headings[h], term)) {
+                        s += 5;
+                        if (!heading) heading = p.page.h[h];
+                        break;
+                    }
+                }
+                if (matches(p.body, term)) s += 1;
+                if (s === 0) return;
+                score += s;
+            }
+            found.push({page: p.page, heading: heading, score: score});
+        });
+        found.sort(function (a, b) { return b.score - a.score; });
+        return found.slice(0, 20).map(function (r) { r.terms = terms; return r; });
+    }
+
+    function render(found) {
+        results.innerHTML = '';
+        found.forEach(function (r) {
+            var url = root + r.page.u + (r.heading && r.heading.i ? '#' + r.heading.i : '');
+            var li = document.createElement('li');
+            li.innerHTML = '<a href="' + escapeHTML(url) + '"><strong>' + highlight(r.page.t, r.terms) + '</strong>' +
+                (r.heading ? '<em>' + highlight(r.heading.t, r.terms) + '</em>' : '') +
+                '<span>' + highlight(snippet(r.page.b, r.terms), r.terms) + '</span></a>';
+            results.appendChild(li);
+        });
+        if (found.length === 0 && input.value.trim()) {
+            results.innerHTML = '<li class="empty">No results</li>';
+        }
+        results.hidden = !input.value.trim();
+    }
+
+    input.addEventListener('input', function () { render(search(input.value)); });
+    input.addEventListener('keydown', function (e) {
+        if (e.key === 'Escape') {
+            input.value = '';
+            render([]);
+        } else if (e.key === 'Enter') {
+            var first = results.querySelector('a');
+            if (first) window.location.href = first.href;
+        }
+    });
+})();
+</script>`, root, root)
+}
+
+const searchCSS = `
+        .site-search {
+            margin-bottom: 16px;
+        }
+
+        .site-search-input {
+            width: 100%;
+            box-sizing: border-box;
+            padding: 5px 8px;
+            font-size: 14px;
+            border: 1px solid #d0d7de;
+            border-radius: 6px;
+        }
+
+        .site-sidebar .site-search-results {
+            margin-top: 8px;
+            max-height: 60vh;
+            overflow-y: auto;
+            background-color: #ffffff;
+            border: 1px solid #d0d7de;
+            border-radius: 6px;
+        }
+
+        .site-sidebar .site-search-results a {
+            border-radius: 0;
+            border-bottom: 1px solid #eaeef2;
+        }
+
+        .site-sidebar .site-search-results strong,
+        .site-sidebar .site-search-results em,
+        .site-sidebar .site-search-results span {
+            display: block;
+            padding: 0;
+        }
+
+        .site-sidebar .site-search-results em {
+            font-style: normal;
+            color: #0366d6;
+        }
+
+        .site-sidebar .site-search-results span {
+            font-size: 12px;
+            color: #57606a;
+        }
+
+        .site-search-results .empty {
+            padding: 8px;
+            color: #57606a;
+        }
+`
@@ -0,0 +1,716 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// sitePage is one Markdown file of a site.
+type sitePage struct {
+	Source   string // path relative to the source directory, slash-separated
+	Output   string // path relative to the site directory, slash-separated
+	Title    string
+	Weight   int
+	Meta     FrontMatter
+	Markdown []byte // the document without its front matter
+	Body     string // the rendered HTML, for feeds
+
+	Section    *siteSection
+	Prev, Next *sitePage
+}
+
+// siteSection is a directory of a site. Its index.md, if any, provides the
+// section's title and weight and is the page its navigation entry links to.
+type siteSection struct {
+	Dir      string // path relative to the source directory, "." for the root
+	Title    string
+	Weight   int
+	Index    *sitePage
+	Parent   *siteSection
+	Pages    []*sitePage
+	Sections []*siteSection
+}
+
+// siteNavItem is a page or a subsection, so both can be sorted together.
+type siteNavItem struct {
+	page    *sitePage
+	section *siteSection
+}
+
+func (item siteNavItem) title() string {
+	if item.page != nil {
+		return item.page.Title
+	}
+	return item.section.Title
+}
+
+func (item siteNavItem) weight() int {
+	if item.page != nil {
+		return item.page.Weight
+	}
+	return item.section.Weight
+}
+
+// items returns the section's pages and subsections in navigation order:
+// by front matter weight, unweighted entries last, then by title.
+func (s *siteSection) items() []siteNavItem {
+	var items []siteNavItem
+	for _, page := range s.Pages {
+		items = append(items, siteNavItem{page: page})
+	}
+	for _, section := range s.Sections {
+		items = append(items, siteNavItem{section: section})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		wi, wj := items[i].weight(), items[j].weight()
+		if (wi == 0) != (wj == 0) {
+			return wj == 0
+		}
+		if wi != wj {
+			return wi < wj
+		}
+		return strings.ToLower(items[i].title()) < strings.ToLower(items[j].title())
+	})
+	return items
+}
+
+// site is a directory of Markdown files rendered as a set of linked pages.
+type site struct {
+	SourceDir string
+	OutputDir string
+	Title     string
+	Root      *siteSection
+	Pages     []*sitePage // in navigation order
+	Search    bool
+
+	BaseURL string
+	Feed    FeedConfig
+	hasFeed bool
+
+	Taxonomies []*taxonomy
+
+	// written holds every file the build has written, relative to
+	// OutputDir, so an alias cannot replace one.
+	written map[string]bool
+}
+
+func runBuild(args []string) {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	var outputDir string
+	var search bool
+	var baseURL string
+	var render renderFlags
+	fs.StringVar(&outputDir, "output", "site", "Output directory for the site")
+	fs.StringVar(&outputDir, "o", "site", "Shorthand for --output")
+	fs.BoolVar(&search, "search", true, "Add an offline full-text search box backed by search-index.js")
+	fs.StringVar(&baseURL, "base-url", "", "URL the site is published at, for sitemap.xml and feeds (default: base_url from the config file)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: mdreader build <docs/> [-o <site/>] [options]")
+		fs.PrintDefaults()
+	}
+
+	// Local assets always have to be copied for the site to work.
+	options.CopyAssets = true
+	options.MarkdownLinks = true
+	render.register(fs)
+
+	// Accept flags before and after the source directory.
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	sourceDir := positional[0]
+	render.apply(fs, sourceDir)
+
+	s, err := loadSite(sourceDir, outputDir)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	s.Search = search
+	s.BaseURL = baseURL
+	if s.BaseURL == "" {
+		s.BaseURL = render.config.BaseURL
+	}
+	s.Feed = render.config.Feed
+	if err := s.build(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	fmt.Printf("Site built: %d pages in %s\n", len(s.Pages), outputDir)
+}
+
+// loadSite reads every Markdown file below sourceDir, skipping hidden files
+// and directories and the output directory itself.
+func loadSite(sourceDir, outputDir string) (*site, error) {
+	sourceAbs, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+	outputAbs, err := filepath.Abs(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &site{
+		SourceDir: sourceAbs,
+		OutputDir: outputAbs,
+		Root:      &siteSection{Dir: ".", Title: humanizeName(filepath.Base(sourceAbs))},
+	}
+	sections := map[string]*siteSection{".": s.Root}
+
+	err = filepath.WalkDir(sourceAbs, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if file != sourceAbs && strings.HasPrefix(d.Name(), ".") || file == outputAbs {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(sourceAbs, file)
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel != "." {
+				parent := sections[path.Dir(rel)]
+				section := &siteSection{Dir: rel, Title: humanizeName(d.Name()), Parent: parent}
+				parent.Sections = append(parent.Sections, section)
+				sections[rel] = section
+			}
+			return nil
+		}
+		if !isMarkdownFile(rel) {
+			return nil
+		}
+
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		meta, markdown := splitFrontMatter(source)
+		section := sections[path.Dir(rel)]
+		page := &sitePage{
+			Source:   rel,
+			Output:   strings.TrimSuffix(rel, path.Ext(rel)) + ".html",
+			Title:    meta.Title,
+			Weight:   meta.Weight,
+			Meta:     meta,
+			Markdown: markdown,
+			Section:  section,
+		}
+		if page.Title == "" {
+			page.Title = firstHeading(markdown)
+		}
+
+		if strings.TrimSuffix(path.Base(rel), path.Ext(rel)) == "index" {
+			section.Index = page
+			if page.Title != "" {
+				section.Title = page.Title
+			}
+			section.Weight = page.Weight
+			if page.Title == "" {
+				page.Title = section.Title
+			}
+		} else {
+			if page.Title == "" {
+				page.Title = humanizeName(strings.TrimSuffix(path.Base(rel), path.Ext(rel)))
+			}
+			section.Pages = append(section.Pages, page)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Title = s.Root.Title
+	s.Root.prune()
+	s.Root.collect(&s.Pages)
+	for i, page := range s.Pages {
+		if i > 0 {
+			page.Prev = s.Pages[i-1]
+		}
+		if i < len(s.Pages)-1 {
+			page.Next = s.Pages[i+1]
+		}
+	}
+	s.collectTaxonomies()
+	return s, nil
+}
+
+// prune drops subsections without any pages, such as image folders, and
+// reports whether sec itself is empty.
+func (sec *siteSection) prune() bool {
+	kept := sec.Sections[:0]
+	for _, sub := range sec.Sections {
+		if !sub.prune() {
+			kept = append(kept, sub)
+		}
+	}
+	sec.Sections = kept
+	return sec.Index == nil && len(sec.Pages) == 0 && len(sec.Sections) == 0
+}
+
+// collect appends the section's pages in reading order: the index first,
+// then each page or subsection in navigation order.
+func (sec *siteSection) collect(pages *[]*sitePage) {
+	if sec.Index != nil {
+		*pages = append(*pages, sec.Index)
+	}
+	for _, item := range sec.items() {
+		if item.page != nil {
+			*pages = append(*pages, item.page)
+		} else {
+			item.section.collect(pages)
+		}
+	}
+}
+
+func isMarkdownFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// firstHeading returns the text of the first level-one ATX heading outside
+// code blocks, or "".
+func firstHeading(markdown []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(markdown))
+	code := newCodeScanner()
+	for scanner.Scan() {
+		line := scanner.Text()
+		if code.inside(line) {
+			continue
+		}
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "# ") {
+			return strings.TrimSpace(strings.TrimRight(line[2:], "#"))
+		}
+	}
+	return ""
+}
+
+// humanizeName turns a file or directory name like "getting-started" into
+// a title like "Getting started".
+func humanizeName(name string) string {
+	name = strings.TrimSpace(strings.NewReplacer("-", " ", "_", " ").Replace(name))
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// build writes the shared stylesheet, every page and the files derived
+// from them: search index, sitemap, feeds, taxonomy pages and alias
+// redirects.
+func (s *site) build() error {
+	if err := os.MkdirAll(s.OutputDir, 0755); err != nil {
+		return err
+	}
+	css := getGithubCSS() + getChromaCSS() + siteCSS + taxonomyCSS
+	if s.Search {
+		css += searchCSS
+	}
+	if err := os.WriteFile(filepath.Join(s.OutputDir, "style.css"), []byte(css), 0644); err != nil {
+		return err
+	}
+	s.written = map[string]bool{"style.css": true}
+
+	if s.BaseURL == "" {
+		log.Printf("Warning: no base URL set (--base-url or base_url in %s), sitemap.xml and feeds not written", configFileName)
+	} else {
+		s.hasFeed = len(s.feedPages()) > 0
+	}
+
+	if !filepath.IsAbs(options.AssetsDir) {
+		options.AssetsDir = filepath.Join(s.OutputDir, options.AssetsDir)
+	}
+	options.WikiRoot = s.SourceDir
+
+	// Every page is parsed before any is rendered, so each can list the
+	// pages linking to it.
+	var index []searchEntry
+	renderers := make([]*CustomHTMLRenderer, len(s.Pages))
+	docs := make([]*blackfriday.Node, len(s.Pages))
+	linked := make([]linkedDocument, len(s.Pages))
+	pages := map[string]*sitePage{}
+	for i, page := range s.Pages {
+		s.setPageOptions(page)
+		renderers[i], docs[i] = parseMarkdown(page.Markdown)
+		if s.Search {
+			index = append(index, searchEntryFor(renderers[i], docs[i], page.Title, page.Output))
+		}
+		linked[i] = linkedDocument{File: s.sourceFile(page), Title: page.Title, Links: renderers[i].links}
+		pages[linked[i].File] = page
+	}
+
+	backlinks := findBacklinks(linked)
+	for i, page := range s.Pages {
+		output := filepath.Join(s.OutputDir, filepath.FromSlash(page.Output))
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return err
+		}
+		s.setPageOptions(page)
+		page.Body = renderers[i].renderDocument(docs[i])
+		refs := renderBacklinks(backlinks[linked[i].File], func(ref backlink) string {
+			return s.link(page, pages[ref.File].Output)
+		})
+		if err := os.WriteFile(output, []byte(s.renderPage(page, page.Body+refs)), 0644); err != nil {
+			return err
+		}
+		s.written[page.Output] = true
+	}
+
+	if s.Search {
+		if err := writeSearchIndex(filepath.Join(s.OutputDir, "search-index.js"), index); err != nil {
+			return err
+		}
+		s.written["search-index.js"] = true
+	}
+	if s.BaseURL != "" {
+		if err := s.writeSitemap(); err != nil {
+			return err
+		}
+		if s.hasFeed {
+			if err := s.writeFeeds(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.writeTaxonomies(); err != nil {
+		return err
+	}
+	return s.writeAliases()
+}
+
+// sourceFile returns the absolute path of a page's Markdown file.
+func (s *site) sourceFile(page *sitePage) string {
+	file, _ := filepath.Abs(filepath.Join(s.SourceDir, filepath.FromSlash(page.Source)))
+	return file
+}
+
+// setPageOptions points relative URLs and copied assets at the page's
+// source and output directories.
+func (s *site) setPageOptions(page *sitePage) {
+	options.BaseDir = filepath.Join(s.SourceDir, filepath.FromSlash(path.Dir(page.Source)))
+	options.OutputDir = filepath.Dir(filepath.Join(s.OutputDir, filepath.FromSlash(page.Output)))
+	options.Source = page.Source
+}
+
+// root returns the relative URL of the site root from page, "" or "../"
+// and so on.
+func (s *site) root(page *sitePage) string {
+	return strings.Repeat("../", strings.Count(page.Output, "/"))
+}
+
+// link returns the URL of target relative to page.
+func (s *site) link(page *sitePage, target string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir(page.Output)), filepath.FromSlash(target))
+	if err != nil {
+		return target
+	}
+	return (&url.URL{Path: filepath.ToSlash(rel)}).String()
+}
+
+func (s *site) renderPage(page *sitePage, body string) string {
+	scripts := getCodeToolsScript(page.Meta)
+	if s.Search {
+		scripts += "\n" + getSearchScript(s.root(page))
+	}
+
+	title := template.HTMLEscapeString(page.Title)
+	if page != s.Root.Index {
+		title += " - " + template.HTMLEscapeString(s.Title)
+	}
+
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>%s</title>
+    <link rel="stylesheet" href="%s">
+    %s
+</head>
+<body class="site">
+    <nav class="site-sidebar">
+        %s
+    </nav>
+    <main class="site-main">
+        %s
+        <div class="markdown-body">
+        %s
+        </div>
+        %s
+        %s
+    </main>
+    %s
+</body>
+</html>`, title, s.link(page, "style.css"), s.feedLinks(page), s.sidebar(page), s.breadcrumbs(page), body, s.pageTerms(page), s.pager(page), scripts)
+}
+
+// sidebar renders the navigation tree with the current page marked.
+func (s *site) sidebar(page *sitePage) string {
+	var b strings.Builder
+	home := "index.html"
+	if s.Root.Index != nil {
+		home = s.Root.Index.Output
+	}
+	fmt.Fprintf(&b, `<a class="site-title" href="%s">%s</a>`, s.link(page, home), template.HTMLEscapeString(s.Title))
+	if s.Search {
+		b.WriteString("\n        " + searchBox)
+	}
+	s.sidebarSection(&b, page, s.Root)
+	b.WriteString(s.taxonomyLinks(page))
+	return b.String()
+}
+
+func (s *site) sidebarSection(b *strings.Builder, page *sitePage, section *siteSection) {
+	b.WriteString("\n<ul>")
+	for _, item := range section.items() {
+		if item.page != nil {
+			b.WriteString("\n<li>" + s.navLink(page, item.page, item.page.Title) + "</li>")
+			continue
+		}
+		sub := item.section
+		class := "site-nav-section"
+		if page.Section.within(sub) {
+			class += " open"
+		}
+		fmt.Fprintf(b, "\n<li class=\"%s\">", class)
+		if sub.Index != nil {
+			b.WriteString(s.navLink(page, sub.Index, sub.Title))
+		} else {
+			b.WriteString("<span>" + template.HTMLEscapeString(sub.Title) + "</span>")
+		}
+		s.sidebarSection(b, page, sub)
+		b.WriteString("</li>")
+	}
+	b.WriteString("\n</ul>")
+}
+
+func (s *site) navLink(page, target *sitePage, title string) string {
+	current := ""
+	if page == target {
+		current = ` class="active" aria-current="page"`
+	}
+	return fmt.Sprintf(`<a href="%s"%s>%s</a>`, s.link(page, target.Output), current, template.HTMLEscapeString(title))
+}
+
+// within reports whether sec is ancestor or one of its subsections.
+func (sec *siteSection) within(ancestor *siteSection) bool {
+	for ; sec != nil; sec = sec.Parent {
+		if sec == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// breadcrumbs renders the path from the home page to page's section.
+func (s *site) breadcrumbs(page *sitePage) string {
+	if page == s.Root.Index {
+		return ""
+	}
+	var trail []*siteSection
+	for sec := page.Section; sec != nil; sec = sec.Parent {
+		trail = append([]*siteSection{sec}, trail...)
+	}
+
+	var crumbs []string
+	for _, sec := range trail {
+		if sec.Index == page {
+			break
+		}
+		name := template.HTMLEscapeString(sec.Title)
+		if sec == s.Root {
+			name = "Home"
+		}
+		if sec.Index != nil {
+			crumbs = append(crumbs, fmt.Sprintf(`<a href="%s">%s</a>`, s.link(page, sec.Index.Output), name))
+		} else {
+			crumbs = append(crumbs, "<span>"+name+"</span>")
+		}
+	}
+	crumbs = append(crumbs, `<span aria-current="page">`+template.HTMLEscapeString(page.Title)+"</span>")
+	return `<nav class="site-breadcrumbs" aria-label="Breadcrumbs">` + strings.Join(crumbs, ` <span class="separator">/</span> `) + "</nav>"
+}
+
+// pager renders the previous and next links.
+func (s *site) pager(page *sitePage) string {
+	if page.Prev == nil && page.Next == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(`<nav class="site-pager">`)
+	if page.Prev != nil {
+		fmt.Fprintf(&b, `<a class="prev" href="%s"><span>Previous</span>%s</a>`, s.link(page, page.Prev.Output), template.HTMLEscapeString(page.Prev.Title))
+	}
+	if page.Next != nil {
+		fmt.Fprintf(&b, `<a class="next" href="%s"><span>Next</span>%s</a>`, s.link(page, page.Next.Output), template.HTMLEscapeString(page.Next.Title))
+	}
+	b.WriteString("</nav>")
+	return b.String()
+}
+
+const siteCSS = `
+        body.site {
+            display: flex;
+            align-items: flex-start;
+            margin: 0;
+        }
+
+        .site-sidebar {
+            position: sticky;
+            top: 0;
+            flex: 0 0 260px;
+            height: 100vh;
+            overflow-y: auto;
+            box-sizing: border-box;
+            padding: 24px 16px;
+            font-size: 14px;
+            background-color: #f6f8fa;
+            border-right: 1px solid #d0d7de;
+        }
+
+        .site-sidebar .site-title {
+            display: block;
+            margin-bottom: 16px;
+            font-size: 16px;
+            font-weight: 600;
+            color: #24292e;
+        }
+
+        .site-sidebar ul {
+            list-style: none;
+            margin: 0;
+            padding-left: 0;
+        }
+
+        .site-sidebar ul ul {
+            padding-left: 14px;
+        }
+
+        .site-sidebar li {
+            margin: 2px 0;
+        }
+
+        .site-sidebar a,
+        .site-sidebar span {
+            display: block;
+            padding: 3px 8px;
+            border-radius: 6px;
+            color: #24292e;
+        }
+
+        .site-sidebar .site-nav-section > a,
+        .site-sidebar .site-nav-section > span {
+            font-weight: 600;
+        }
+
+        .site-sidebar .site-nav-section:not(.open) > ul {
+            display: none;
+        }
+
+        .site-sidebar a:hover {
+            text-decoration: none;
+            background-color: #eaeef2;
+        }
+
+        .site-sidebar a.active {
+            color: #0366d6;
+            background-color: #ddf4ff;
+        }
+
+        .site-main {
+            flex: 1;
+            min-width: 0;
+        }
+
+        .site-breadcrumbs {
+            max-width: 980px;
+            margin: 0 auto;
+            padding: 24px 45px 0;
+            box-sizing: border-box;
+            font-size: 14px;
+            color: #57606a;
+        }
+
+        .site-breadcrumbs .separator {
+            margin: 0 4px;
+        }
+
+        .site-pager {
+            display: flex;
+            justify-content: space-between;
+            gap: 16px;
+            max-width: 980px;
+            margin: 0 auto;
+            padding: 0 45px 45px;
+            box-sizing: border-box;
+        }
+
+        .site-pager a {
+            flex: 1;
+            padding: 12px 16px;
+            border: 1px solid #d0d7de;
+            border-radius: 6px;
+        }
+
+        .site-pager a span {
+            display: block;
+            font-size: 12px;
+            color: #57606a;
+        }
+
+        .site-pager a.next {
+            text-align: right;
+        }
+
+        .site-pager a.next:only-child {
+            margin-left: auto;
+            flex: 0 1 50%;
+        }
+
+        @media (max-width: 767px) {
+            body.site {
+                display: block;
+            }
+
+            .site-sidebar {
+                position: static;
+                height: auto;
+                border-right: 0;
+                border-bottom: 1px solid #d0d7de;
+            }
+
+            .site-breadcrumbs,
+            .site-pager {
+                padding-left: 15px;
+                padding-right: 15px;
+            }
+        }
+`
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestFirstHeadingSkipsCode(t *testing.T) {
+	for source, want := range map[string]string{
+		"~~~sh\n# install\n~~~\n\n# Title\n":                 "Title",
+		"````md\n```\n# Inside\n````\n# Title\n":             "Title",
+		"```sh title=\"setup\"\n# comment\n```\n# Title #\n": "Title",
+		"Intro:\n\n    # indented comment\n\n# Title\n":      "Title",
+		"## Section\n\n```\n# comment\n```\n":                "",
+		"```\n# unterminated fence\n\n# Still code\n":        "",
+	} {
+		if got := firstHeading([]byte(source)); got != want {
+			t.Errorf("firstHeading(%q) = %q, want %q", source, got, want)
+		}
+	}
+}
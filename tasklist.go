@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// taskLinePattern matches a list item line that starts with a task marker,
+// including items nested inside blockquotes.
+var taskLinePattern = regexp.MustCompile(`^\s*(?:>\s*)*(?:[-*+]|\d+[.)])\s+\[[ xX]\](?:\s|$)`)
+
+// findTaskLines returns the 1-based source line of every task list item in
+// document order. Fenced and indented code blocks are skipped so the result
+// lines up with the checkboxes emitted by the renderer.
+func findTaskLines(markdown []byte) []int {
+	var lines []int
+	code := newCodeScanner()
+	scanner := bufio.NewScanner(bytes.NewReader(markdown))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(markdown)+1)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if !code.inside(line) && code.item && taskLinePattern.MatchString(line) {
+			lines = append(lines, lineNo)
+		}
+	}
+	return lines
+}
+
+// countTasks returns how many task checkboxes doc will render.
+func countTasks(doc *blackfriday.Node) int {
+	n := 0
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && isTaskText(node) {
+			n++
+		}
+		return blackfriday.GoToNext
+	})
+	return n
+}
+
+// taskMarker reports whether the item starts with a "[ ]" or "[x]" marker
+// and, if so, whether it is checked.
+func taskMarker(item *blackfriday.Node) (checked bool, ok bool) {
+	if item == nil || item.Type != blackfriday.Item {
+		return false, false
+	}
+	para := item.FirstChild
+	if para == nil || para.Type != blackfriday.Paragraph {
+		return false, false
+	}
+	text := para.FirstChild
+	if text == nil || text.Type != blackfriday.Text || len(text.Literal) < 3 {
+		return false, false
+	}
+	lit := text.Literal
+	if lit[0] != '[' || lit[2] != ']' || (len(lit) > 3 && lit[3] != ' ') {
+		return false, false
+	}
+	switch lit[1] {
+	case ' ':
+		return false, true
+	case 'x', 'X':
+		return true, true
+	}
+	return false, false
+}
+
+// isTaskText reports whether node is the text run carrying a task marker.
+func isTaskText(node *blackfriday.Node) bool {
+	if node.Type != blackfriday.Text || node.Prev != nil || node.Parent == nil {
+		return false
+	}
+	para := node.Parent
+	if para.Type != blackfriday.Paragraph || para.Prev != nil {
+		return false
+	}
+	_, ok := taskMarker(para.Parent)
+	return ok
+}
+
+func (r *CustomHTMLRenderer) renderTaskCheckbox(w io.Writer, node *blackfriday.Node) {
+	checked, _ := taskMarker(node.Parent.Parent)
+
+	attrs := `type="checkbox" class="task-list-item-checkbox" disabled`
+	if checked {
+		attrs += ` checked`
+	}
+	// Tasks from included files have no line of their own to toggle.
+	if r.taskIndex < len(r.taskLines) && r.taskLines[r.taskIndex] > 0 {
+		attrs += fmt.Sprintf(` data-task-line="%d"`, r.taskLines[r.taskIndex])
+	}
+	r.taskIndex++
+	fmt.Fprintf(w, "<input %s> ", attrs)
+
+	node.Literal = bytes.TrimLeft(node.Literal[3:], " ")
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// taxonomy groups a site's pages by one front matter list, such as tags.
+type taxonomy struct {
+	Dir   string // output directory, e.g. "tags"
+	Title string // e.g. "Tags"
+	Label string // heading of a term page, e.g. "Tagged"
+	Terms map[string]*taxonomyTerm
+
+	section *siteSection // for breadcrumbs of the generated pages
+}
+
+// taxonomyTerm is one tag or category and the pages that carry it.
+type taxonomyTerm struct {
+	Name  string // as first written in front matter
+	Slug  string
+	Pages []*sitePage
+}
+
+func (tax *taxonomy) termFile(term *taxonomyTerm) string {
+	return path.Join(tax.Dir, term.Slug+".html")
+}
+
+func (tax *taxonomy) indexFile() string {
+	return path.Join(tax.Dir, "index.html")
+}
+
+// sorted returns the terms ordered by name.
+func (tax *taxonomy) sorted() []*taxonomyTerm {
+	terms := make([]*taxonomyTerm, 0, len(tax.Terms))
+	for _, term := range tax.Terms {
+		terms = append(terms, term)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		return strings.ToLower(terms[i].Name) < strings.ToLower(terms[j].Name)
+	})
+	return terms
+}
+
+// collectTaxonomies groups the pages by tags and categories. Terms that
+// differ only in case or punctuation share a page; see termSlug.
+func (s *site) collectTaxonomies() {
+	s.Taxonomies = []*taxonomy{
+		{Dir: "tags", Title: "Tags", Label: "Tagged", Terms: map[string]*taxonomyTerm{}},
+		{Dir: "categories", Title: "Categories", Label: "Category", Terms: map[string]*taxonomyTerm{}},
+	}
+	for _, page := range s.Pages {
+		for i, names := range []StringList{page.Meta.Tags, page.Meta.Categories} {
+			tax := s.Taxonomies[i]
+			for _, name := range names {
+				slug := termSlug(name)
+				if slug == "" {
+					continue
+				}
+				term, ok := tax.Terms[slug]
+				if !ok {
+					term = &taxonomyTerm{Name: strings.TrimSpace(name), Slug: slug}
+					tax.Terms[slug] = term
+				}
+				if len(term.Pages) == 0 || term.Pages[len(term.Pages)-1] != page {
+					term.Pages = append(term.Pages, page)
+				}
+			}
+		}
+	}
+
+	for _, tax := range s.Taxonomies {
+		tax.section = &siteSection{Dir: tax.Dir, Title: tax.Title, Parent: s.Root}
+		tax.section.Index = &sitePage{Output: tax.indexFile(), Title: tax.Title, Section: tax.section}
+		for _, term := range tax.Terms {
+			sort.SliceStable(term.Pages, func(i, j int) bool {
+				a, b := term.Pages[i].Meta.Date, term.Pages[j].Meta.Date
+				if !a.Equal(b.Time) {
+					return a.After(b.Time)
+				}
+				return strings.ToLower(term.Pages[i].Title) < strings.ToLower(term.Pages[j].Title)
+			})
+		}
+	}
+}
+
+// termSymbols spells out the symbols that tell terms like C, C++ and C#
+// apart.
+var termSymbols = map[rune]string{'+': "plus", '#': "sharp", '&': "and"}
+
+// termSlug turns a tag into a file name: lower case, with termSymbols
+// spelled out and runs of anything else other than letters and digits
+// replaced by a single "-".
+func termSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			dash = false
+		case termSymbols[r] != "":
+			if !dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteString(termSymbols[r] + "-")
+			dash = true
+		case !dash && b.Len() > 0:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// writeTaxonomies writes a page per term and an index of all terms for
+// every taxonomy in use.
+func (s *site) writeTaxonomies() error {
+	for _, tax := range s.Taxonomies {
+		if len(tax.Terms) == 0 {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Join(s.OutputDir, tax.Dir), 0755); err != nil {
+			return err
+		}
+
+		write := func(page *sitePage, body string) error {
+			if s.written[page.Output] {
+				log.Printf("Warning: %s would replace a page, skipped", page.Output)
+				return nil
+			}
+			file := filepath.Join(s.OutputDir, filepath.FromSlash(page.Output))
+			if err := os.WriteFile(file, []byte(s.renderPage(page, body)), 0644); err != nil {
+				return err
+			}
+			s.written[page.Output] = true
+			return nil
+		}
+
+		index := tax.section.Index
+		if err := write(index, s.termCloud(index, tax)); err != nil {
+			return err
+		}
+		for _, term := range tax.sorted() {
+			page := &sitePage{
+				Output:  tax.termFile(term),
+				Title:   tax.Label + " “" + term.Name + "”",
+				Section: tax.section,
+			}
+			if err := write(page, s.termPageList(page, term)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// termCloud renders the index of a taxonomy, sizing each term by how many
+// pages carry it.
+func (s *site) termCloud(page *sitePage, tax *taxonomy) string {
+	most := 1
+	for _, term := range tax.Terms {
+		if len(term.Pages) > most {
+			most = len(term.Pages)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<h1>%s</h1>\n<p class=\"term-cloud\">\n", template.HTMLEscapeString(tax.Title))
+	for _, term := range tax.sorted() {
+		// Five sizes on a log scale, so a few popular terms do not dwarf
+		// everything else.
+		size := 1
+		if most > 1 {
+			size = 1 + int(math.Round(4*math.Log(float64(len(term.Pages)))/math.Log(float64(most))))
+		}
+		fmt.Fprintf(&b, "<a class=\"term term-size-%d\" href=\"%s\">%s <span class=\"term-count\">%d</span></a>\n",
+			size, s.link(page, tax.termFile(term)), template.HTMLEscapeString(term.Name), len(term.Pages))
+	}
+	b.WriteString("</p>\n")
+	return b.String()
+}
+
+// termPageList renders the list of pages carrying a term.
+func (s *site) termPageList(page *sitePage, term *taxonomyTerm) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<h1>%s</h1>\n<ul class=\"term-pages\">\n", template.HTMLEscapeString(page.Title))
+	for _, p := range term.Pages {
+		date := ""
+		if !p.Meta.Date.IsZero() {
+			date = fmt.Sprintf(` <time datetime="%s">%s</time>`, p.Meta.Date.Format("2006-01-02"), p.Meta.Date.Format("2 Jan 2006"))
+		}
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a>%s</li>\n", s.link(page, p.Output), template.HTMLEscapeString(p.Title), date)
+	}
+	b.WriteString("</ul>\n")
+	return b.String()
+}
+
+// pageTerms renders links to the tags and categories of a page.
+func (s *site) pageTerms(page *sitePage) string {
+	var parts []string
+	for i, names := range []StringList{page.Meta.Tags, page.Meta.Categories} {
+		tax := s.Taxonomies[i]
+		var links []string
+		for _, name := range names {
+			if term, ok := tax.Terms[termSlug(name)]; ok {
+				links = append(links, fmt.Sprintf(`<a class="term" href="%s">%s</a>`, s.link(page, tax.termFile(term)), template.HTMLEscapeString(term.Name)))
+			}
+		}
+		if len(links) > 0 {
+			parts = append(parts, fmt.Sprintf(`<span class="site-terms-label">%s:</span> %s`, tax.Title, strings.Join(links, " ")))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return `<div class="site-terms">` + strings.Join(parts, "<br>") + "</div>"
+}
+
+// taxonomyLinks renders sidebar links to the taxonomy indexes in use.
+func (s *site) taxonomyLinks(page *sitePage) string {
+	var links []string
+	for _, tax := range s.Taxonomies {
+		if len(tax.Terms) > 0 {
+			links = append(links, "<li>"+s.navLink(page, tax.section.Index, tax.Title)+"</li>")
+		}
+	}
+	if len(links) == 0 {
+		return ""
+	}
+	return "\n<ul class=\"site-nav-taxonomies\">\n" + strings.Join(links, "\n") + "\n</ul>"
+}
+
+const taxonomyCSS = `
+        .site-sidebar .site-nav-taxonomies {
+            margin-top: 16px;
+            padding-top: 8px;
+            border-top: 1px solid #d0d7de;
+        }
+
+        .site-terms {
+            max-width: 980px;
+            margin: 0 auto 24px;
+            padding: 0 45px;
+            box-sizing: border-box;
+            font-size: 14px;
+            line-height: 2;
+        }
+
+        .site-terms-label {
+            color: #57606a;
+        }
+
+        .site-terms .term,
+        .term-cloud .term {
+            display: inline-block;
+            margin: 0 4px 4px 0;
+            padding: 0 10px;
+            line-height: 22px;
+            border-radius: 11px;
+            background-color: #ddf4ff;
+        }
+
+        .term-cloud {
+            line-height: 2.2;
+        }
+
+        .term-cloud .term-count {
+            font-size: 12px;
+            color: #57606a;
+        }
+
+        .term-cloud .term-size-1 { font-size: 13px; }
+        .term-cloud .term-size-2 { font-size: 15px; }
+        .term-cloud .term-size-3 { font-size: 18px; }
+        .term-cloud .term-size-4 { font-size: 21px; }
+        .term-cloud .term-size-5 { font-size: 25px; }
+
+        .term-pages time {
+            margin-left: 8px;
+            font-size: 12px;
+            color: #57606a;
+        }
+
+        @media (max-width: 767px) {
+            .site-terms {
+                padding: 0 15px;
+            }
+        }
+`
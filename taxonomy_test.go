@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestTermSlug(t *testing.T) {
+	for name, want := range map[string]string{
+		"C":             "c",
+		"C++":           "c-plus-plus",
+		"C#":            "c-sharp",
+		"F# / .NET":     "f-sharp-net",
+		"R&D":           "r-and-d",
+		"  Go Modules ": "go-modules",
+		"go-modules":    "go-modules",
+		"Ünïcode!":      "ünïcode",
+		"---":           "",
+	} {
+		if got := termSlug(name); got != want {
+			t.Errorf("termSlug(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestDistinctTermsGetDistinctPages(t *testing.T) {
+	s := &site{Pages: []*sitePage{
+		{Title: "One", Meta: FrontMatter{Tags: StringList{"C"}}},
+		{Title: "Two", Meta: FrontMatter{Tags: StringList{"C++"}}},
+		{Title: "Three", Meta: FrontMatter{Tags: StringList{"C#", "c"}}},
+	}}
+	s.collectTaxonomies()
+	terms := s.Taxonomies[0].Terms
+	if len(terms) != 3 {
+		t.Fatalf("got %d tag pages, want 3: %v", len(terms), terms)
+	}
+	if n := len(terms["c"].Pages); n != 2 {
+		t.Errorf("tag C has %d pages, want 2", n)
+	}
+}
@@ -20,12 +20,18 @@ var upgrader = websocket.Upgrader{
 }
 
 type Message struct {
-	Type    string `json:"type"`
-	Content string `json:"content"`
-	Name    string `json:"name,omitempty"`
+	Type        string       `json:"type"`
+	Content     string       `json:"content"`
+	Name        string       `json:"name,omitempty"`
+	Base        string       `json:"base,omitempty"`
+	Diagnostics []diagnostic `json:"diagnostics,omitempty"`
+
+	// The code block tools of a preview, which the editor adds itself.
+	CopyButtons   bool `json:"copyButtons,omitempty"`
+	CollapseLines int  `json:"collapseLines,omitempty"`
 }
 
-func runUI(initialFile string) {
+func runUI(initialFile string, lint lintSettings, format formatStyle) {
 	initialContent := ""
 	if initialFile != "" {
 		content, err := os.ReadFile(initialFile)
@@ -34,12 +40,28 @@ func runUI(initialFile string) {
 		}
 	}
 
+	work, err := newWorkspace()
+	if err != nil {
+		log.Fatalf("Error opening workspace: %v", err)
+	}
+	work.lint = lint
+	work.format = format
+	options.WikiRoot = work.root
+	wikiIndexMaxAge = 2 * time.Second
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, generateUIHTML(initialContent))
+		fmt.Fprint(w, generateUIHTML(initialFile, initialContent))
 	})
 
-	http.HandleFunc("/ws", handleWebSocket)
+	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(w, r, work)
+	})
+
+	http.HandleFunc("/files/", work.serveFiles)
+	http.HandleFunc("/api/upload", work.handleUpload)
+	http.HandleFunc("/api/backlinks", work.handleBacklinks)
+	http.HandleFunc("/api/format", work.handleFormat)
 
 	http.HandleFunc("/api/save", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
@@ -110,7 +132,7 @@ func runUI(initialFile string) {
 	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
 
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+func handleWebSocket(w http.ResponseWriter, r *http.Request, work *workspace) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Print("upgrade failed: ", err)
@@ -128,15 +150,29 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 		switch msg.Type {
 		case "convert":
-			html := convertMarkdownToHTMLForUI([]byte(msg.Content))
+			html := work.convertPreview(msg.Name, msg.Content)
 			if len(html) > 100 {
 				log.Printf("Generated HTML preview (%d chars): %s...", len(html), html[:100])
 			}
+			// The base only applies inside the preview frame, so exported
+			// HTML keeps its relative URLs.
+			meta, _ := splitFrontMatter([]byte(msg.Content))
+			copyButtons, collapseLines := codeToolsSettings(meta)
 			response := Message{
-				Type:    "preview",
-				Content: html,
+				Type:          "preview",
+				Content:       html,
+				Base:          work.previewBase(msg.Name),
+				CopyButtons:   copyButtons,
+				CollapseLines: collapseLines,
 			}
 			conn.WriteJSON(response)
+
+			// Problems in the document follow its preview, so the
+			// editor gets them from the same round-trip.
+			conn.WriteJSON(Message{
+				Type:        "diagnostics",
+				Diagnostics: work.diagnose(msg.Name, msg.Content),
+			})
 		}
 	}
 }
@@ -162,9 +198,24 @@ func openBrowserUI(url string) error {
 	return exec.Command(cmd, args...).Start()
 }
 
-func generateUIHTML(initialContent string) string {
+// previewSandbox returns the sandbox attribute for the preview iframe.
+// Scripts never run in the preview; same-origin access stays so the editor
+// can sync scrolling, handle task checkbox clicks and add the code block
+// tools.
+func previewSandbox() string {
+	if !options.Safe {
+		return ""
+	}
+	return `sandbox="allow-same-origin allow-popups allow-popups-to-escape-sandbox"`
+}
+
+func generateUIHTML(initialFile, initialContent string) string {
 	// Use JSON encoding to properly escape the content for JavaScript
 	contentJSON, _ := json.Marshal(initialContent)
+	if initialFile == "" {
+		initialFile = "Untitled.md"
+	}
+	filenameJSON, _ := json.Marshal(initialFile)
 	
 	return `<!DOCTYPE html>
 <html>
@@ -248,8 +299,17 @@ func generateUIHTML(initialContent string) string {
             font-weight: 500;
         }
 
+        .editor-area {
+            flex: 1;
+            display: flex;
+            position: relative;
+            min-height: 0;
+            background: #1e1e1e;
+        }
+
         #editor {
             flex: 1;
+            position: relative;
             padding: 20px;
             font-family: 'SF Mono', Monaco, 'Cascadia Code', 'Roboto Mono', Consolas, 'Courier New', monospace;
             font-size: 14px;
@@ -257,9 +317,70 @@ func generateUIHTML(initialContent string) string {
             border: none;
             outline: none;
             resize: none;
-            background: #1e1e1e;
+            background: transparent;
             color: #d4d4d4;
             tab-size: 4;
+            white-space: pre-wrap;
+            overflow-wrap: break-word;
+        }
+
+        /* A copy of the editor's text in transparent ink behind it, to draw
+           problem markers in the margin and underlines under the text */
+        .editor-highlights {
+            position: absolute;
+            top: 0;
+            left: 0;
+            right: 0;
+            bottom: 0;
+            padding: 20px;
+            overflow: hidden;
+            font-family: 'SF Mono', Monaco, 'Cascadia Code', 'Roboto Mono', Consolas, 'Courier New', monospace;
+            font-size: 14px;
+            line-height: 1.6;
+            color: transparent;
+            tab-size: 4;
+            white-space: pre-wrap;
+            overflow-wrap: break-word;
+        }
+
+        .editor-highlights .line {
+            position: relative;
+        }
+
+        .editor-highlights .line.error::before,
+        .editor-highlights .line.warning::before,
+        .editor-highlights .line.info::before {
+            content: '';
+            position: absolute;
+            left: -14px;
+            top: 7px;
+            width: 8px;
+            height: 8px;
+            border-radius: 50%;
+            background: #f14c4c;
+        }
+
+        .editor-highlights .line.warning::before {
+            background: #cca700;
+        }
+
+        .editor-highlights .line.info::before {
+            background: #3794ff;
+        }
+
+        .editor-highlights mark {
+            background: none;
+            color: transparent;
+            text-decoration: underline wavy #f14c4c;
+            text-decoration-skip-ink: none;
+        }
+
+        .editor-highlights mark.warning {
+            text-decoration-color: #cca700;
+        }
+
+        .editor-highlights mark.info {
+            text-decoration-color: #3794ff;
         }
 
         #editor::selection {
@@ -345,6 +466,93 @@ func generateUIHTML(initialContent string) string {
             color: #cccccc;
         }
 
+        .backlinks-panel,
+        .problems-panel {
+            max-height: 30%;
+            display: flex;
+            flex-direction: column;
+            background: #252526;
+            border-top: 1px solid #3e3e42;
+        }
+
+        .backlinks-panel .pane-header,
+        .problems-panel .pane-header {
+            cursor: pointer;
+            user-select: none;
+        }
+
+        .backlinks-panel.collapsed ul,
+        .problems-panel.collapsed ul {
+            display: none;
+        }
+
+        .backlinks-panel ul,
+        .problems-panel ul {
+            list-style: none;
+            overflow-y: auto;
+            padding: 6px 15px;
+        }
+
+        .backlinks-panel li,
+        .problems-panel li {
+            padding: 4px 0;
+            font-size: 13px;
+        }
+
+        .backlinks-panel a {
+            color: #3794ff;
+            text-decoration: none;
+        }
+
+        .backlinks-panel a:hover {
+            text-decoration: underline;
+        }
+
+        .backlinks-panel p,
+        .backlinks-panel .empty,
+        .problems-panel .empty {
+            color: #969696;
+            font-size: 12px;
+        }
+
+        .problems-panel li {
+            display: flex;
+            gap: 8px;
+            color: #cccccc;
+            cursor: pointer;
+        }
+
+        .problems-panel li.empty {
+            cursor: default;
+        }
+
+        .problems-panel li:not(.empty):hover {
+            background: #2a2d2e;
+        }
+
+        .problems-panel .severity {
+            flex: none;
+            color: #f14c4c;
+        }
+
+        .problems-panel .warning .severity {
+            color: #cca700;
+        }
+
+        .problems-panel .info .severity {
+            color: #3794ff;
+        }
+
+        .problems-panel .position,
+        .problems-panel .rule {
+            flex: none;
+            color: #969696;
+        }
+
+        .problems-panel .message {
+            flex: 1;
+        }
+
         .overlay {
             display: none;
             position: fixed;
@@ -365,6 +573,7 @@ func generateUIHTML(initialContent string) string {
         <button onclick="saveAsDialog()">Save As</button>
         <div class="separator"></div>
         <button onclick="exportHTML()">Export HTML</button>
+        <button onclick="formatDocument()" title="Format document (Shift+Alt+F)">Format</button>
         <div class="separator"></div>
         <button id="scroll-sync-btn" onclick="toggleScrollSync()" title="Toggle scroll synchronization">🔗 Sync</button>
         <div class="separator"></div>
@@ -374,14 +583,25 @@ func generateUIHTML(initialContent string) string {
     <div class="container">
         <div class="pane">
             <div class="pane-header">MARKDOWN EDITOR</div>
-            <textarea id="editor" placeholder="Start typing markdown..." spellcheck="false"></textarea>
+            <div class="editor-area">
+                <div class="editor-highlights" id="editor-highlights" aria-hidden="true"></div>
+                <textarea id="editor" placeholder="Start typing markdown..." spellcheck="false"></textarea>
+            </div>
+            <div class="problems-panel" id="problems-panel">
+                <div class="pane-header" onclick="toggleProblems()" title="Lint and link check problems in this document">PROBLEMS (<span id="problems-count">0</span>)</div>
+                <ul id="problems-list"></ul>
+            </div>
         </div>
         
         <div class="divider" id="divider"></div>
         
         <div class="pane">
             <div class="pane-header">PREVIEW</div>
-            <iframe id="preview-frame"></iframe>
+            <iframe id="preview-frame" ` + previewSandbox() + `></iframe>
+            <div class="backlinks-panel" id="backlinks-panel">
+                <div class="pane-header" onclick="toggleBacklinks()" title="Documents in the workspace linking here">LINKED REFERENCES (<span id="backlinks-count">0</span>)</div>
+                <ul id="backlinks-list"></ul>
+            </div>
         </div>
     </div>
 
@@ -411,9 +631,11 @@ func generateUIHTML(initialContent string) string {
     </div>
 
     <script>
-        let currentFilename = 'Untitled.md';
+        let currentFilename = ` + string(filenameJSON) + `;
         let isDirty = false;
         let lastSavedContent = '';
+        let previewHTML = '';
+        let previewCodeTools = {copyButtons: false, collapseLines: 0};
         let ws = null;
 
         const editor = document.getElementById('editor');
@@ -435,12 +657,20 @@ func generateUIHTML(initialContent string) string {
             ws.onmessage = (event) => {
                 const msg = JSON.parse(event.data);
                 if (msg.type === 'preview') {
-                    preview.srcdoc = msg.content;
+                    previewHTML = msg.content;
+                    previewCodeTools = {copyButtons: !!msg.copyButtons, collapseLines: msg.collapseLines || 0};
+                    const base = document.createElement('base');
+                    base.href = msg.base || '/files/';
+                    preview.srcdoc = msg.content.replace('<head>', '<head>\n    ' + base.outerHTML);
                     statusText.textContent = 'Preview updated';
                     // Set up reverse scroll sync after content loads
                     setTimeout(() => {
                         setupReverseScrollSync();
                     }, 100);
+                } else if (msg.type === 'diagnostics') {
+                    diagnostics = msg.diagnostics || [];
+                    renderHighlights();
+                    renderProblems();
                 }
             };
 
@@ -468,6 +698,7 @@ func generateUIHTML(initialContent string) string {
         // Update preview on input
         let updateTimer;
         editor.addEventListener('input', () => {
+            renderHighlights();
             clearTimeout(updateTimer);
             updateTimer = setTimeout(updatePreview, 300);
             checkDirty();
@@ -524,6 +755,343 @@ func generateUIHTML(initialContent string) string {
 
         // Set up reverse scroll sync after preview loads
         preview.addEventListener('load', setupReverseScrollSync);
+        preview.addEventListener('load', setupTaskCheckboxes);
+        preview.addEventListener('load', setupPreviewLinks);
+        preview.addEventListener('load', setupCodeTools);
+
+        // The sandboxed preview runs no script of its own, so the copy
+        // buttons and collapse toggles are added from here.
+        ` + codeToolsJS + `
+
+        function setupCodeTools() {
+            const previewDoc = preview.contentDocument || preview.contentWindow.document;
+            if (!previewDoc) return;
+            if (previewCodeTools.copyButtons || previewCodeTools.collapseLines > 0) {
+                codeTools(preview.contentWindow, previewCodeTools.copyButtons, previewCodeTools.collapseLines);
+            }
+        }
+
+        // The preview has a <base> pointing at the document's directory so
+        // relative URLs load from the workspace. In-page links scroll the
+        // preview, links to other Markdown files open them in the editor and
+        // anything else opens in a new tab rather than replacing the preview.
+        let pendingAnchor = '';
+
+        function setupPreviewLinks() {
+            const previewDoc = preview.contentDocument || preview.contentWindow.document;
+            if (!previewDoc) return;
+
+            if (pendingAnchor) {
+                scrollPreviewTo(previewDoc, pendingAnchor);
+                pendingAnchor = '';
+            }
+
+            previewDoc.querySelectorAll('a[href]').forEach((link) => {
+                const href = link.getAttribute('href');
+                if (href.startsWith('#')) {
+                    link.addEventListener('click', (e) => {
+                        e.preventDefault();
+                        scrollPreviewTo(previewDoc, href.slice(1));
+                    });
+                    return;
+                }
+
+                const url = new URL(link.href);
+                const isWorkspaceFile = url.origin === location.origin && url.pathname.startsWith('/files/');
+                if (isWorkspaceFile && /\.(md|markdown)$/i.test(url.pathname)) {
+                    link.addEventListener('click', (e) => {
+                        e.preventDefault();
+                        openLinkedDocument(decodeURIComponent(url.pathname.slice('/files/'.length)), url.hash.slice(1));
+                    });
+                    return;
+                }
+                link.target = '_blank';
+                link.rel = 'noopener';
+            });
+        }
+
+        function scrollPreviewTo(previewDoc, anchor) {
+            const target = previewDoc.getElementById(decodeURIComponent(anchor));
+            if (target) target.scrollIntoView();
+        }
+
+        async function openLinkedDocument(filename, anchor) {
+            if (isDirty && !confirm('You have unsaved changes. Continue without saving?')) {
+                return;
+            }
+            if (await loadFile(filename)) {
+                pendingAnchor = anchor;
+            }
+        }
+
+        // Linked references: the workspace documents linking to the open
+        // one, refreshed when a document is opened or saved
+        const backlinksPanel = document.getElementById('backlinks-panel');
+        const backlinksList = document.getElementById('backlinks-list');
+        const backlinksCount = document.getElementById('backlinks-count');
+
+        function toggleBacklinks() {
+            backlinksPanel.classList.toggle('collapsed');
+        }
+
+        async function updateBacklinks() {
+            let backlinks = [];
+            try {
+                const response = await fetch('/api/backlinks', {
+                    method: 'POST',
+                    headers: {'Content-Type': 'application/json'},
+                    body: JSON.stringify({document: currentFilename})
+                });
+                if (response.ok) {
+                    backlinks = (await response.json()).backlinks;
+                }
+            } catch (error) {
+                console.error('Error loading backlinks:', error);
+            }
+
+            backlinksCount.textContent = backlinks.length;
+            backlinksList.innerHTML = '';
+            if (backlinks.length === 0) {
+                const empty = document.createElement('li');
+                empty.className = 'empty';
+                empty.textContent = 'No other document links here';
+                backlinksList.appendChild(empty);
+            }
+            backlinks.forEach((ref) => {
+                const item = document.createElement('li');
+                const link = document.createElement('a');
+                link.href = '#';
+                link.textContent = ref.title;
+                link.title = ref.file;
+                link.addEventListener('click', (e) => {
+                    e.preventDefault();
+                    openLinkedDocument(ref.file, '');
+                });
+                item.appendChild(link);
+                if (ref.context) {
+                    const context = document.createElement('p');
+                    context.textContent = ref.context;
+                    item.appendChild(context);
+                }
+                backlinksList.appendChild(item);
+            });
+        }
+
+        updateBacklinks();
+
+        // Problems: the lint and link check diagnostics sent after each
+        // preview, marked in the editor and listed in the problems panel
+        const highlights = document.getElementById('editor-highlights');
+        const problemsPanel = document.getElementById('problems-panel');
+        const problemsList = document.getElementById('problems-list');
+        const problemsCount = document.getElementById('problems-count');
+        const severityRank = {info: 1, warning: 2, error: 3};
+        let diagnostics = [];
+
+        function toggleProblems() {
+            problemsPanel.classList.toggle('collapsed');
+        }
+
+        // Diagnostics count columns in characters; JavaScript strings in
+        // UTF-16 units
+        function columnOffset(text, column) {
+            let offset = 0;
+            for (let i = 1; i < column && offset < text.length; i++) {
+                offset += text.codePointAt(offset) > 0xffff ? 2 : 1;
+            }
+            return offset;
+        }
+
+        // A problem is underlined to the end of the word it starts at, or
+        // of the whitespace, such as trailing spaces
+        function underlineEnd(text, start) {
+            const run = /^(\s+|\S+)/.exec(text.slice(start));
+            return start + run[0].length;
+        }
+
+        // Redraw the copy of the text behind the editor. It follows every
+        // edit, so markers stay on their lines until new diagnostics come.
+        function renderHighlights() {
+            const byLine = new Map();
+            diagnostics.forEach((d) => {
+                if (!byLine.has(d.line)) byLine.set(d.line, []);
+                byLine.get(d.line).push(d);
+            });
+
+            highlights.innerHTML = '';
+            editor.value.split('\n').forEach((text, i) => {
+                const line = document.createElement('div');
+                line.className = 'line';
+                const problems = byLine.get(i + 1) || [];
+                let worst = '';
+                let pos = 0;
+                problems
+                    .map((d) => ({severity: d.severity, start: columnOffset(text, d.column)}))
+                    .sort((a, b) => a.start - b.start)
+                    .forEach((p) => {
+                        if (!worst || severityRank[p.severity] > severityRank[worst]) worst = p.severity;
+                        const start = Math.max(p.start, pos);
+                        if (start >= text.length) return;
+                        const end = underlineEnd(text, start);
+                        line.appendChild(document.createTextNode(text.slice(pos, start)));
+                        const mark = document.createElement('mark');
+                        mark.className = p.severity;
+                        mark.textContent = text.slice(start, end);
+                        line.appendChild(mark);
+                        pos = end;
+                    });
+                // An empty line needs some text to take up its height
+                line.appendChild(document.createTextNode(text === '' ? '\u200b' : text.slice(pos)));
+                if (worst) line.classList.add(worst);
+                highlights.appendChild(line);
+            });
+            // Leave room for the editor's scrollbar so lines wrap alike
+            highlights.style.paddingRight = (20 + editor.offsetWidth - editor.clientWidth) + 'px';
+            highlights.scrollTop = editor.scrollTop;
+        }
+
+        function renderProblems() {
+            problemsCount.textContent = diagnostics.length;
+            problemsList.innerHTML = '';
+            if (diagnostics.length === 0) {
+                const empty = document.createElement('li');
+                empty.className = 'empty';
+                empty.textContent = 'No problems found';
+                problemsList.appendChild(empty);
+            }
+            diagnostics.forEach((d) => {
+                const item = document.createElement('li');
+                item.className = d.severity;
+                [
+                    ['severity', d.severity === 'error' ? '●' : d.severity === 'warning' ? '▲' : 'ℹ'],
+                    ['position', 'Ln ' + d.line + ', Col ' + d.column],
+                    ['message', d.message],
+                    ['rule', d.rule]
+                ].forEach(([name, text]) => {
+                    const span = document.createElement('span');
+                    span.className = name;
+                    span.textContent = text;
+                    item.appendChild(span);
+                });
+                item.addEventListener('click', () => goToProblem(d));
+                problemsList.appendChild(item);
+            });
+        }
+
+        // Put the cursor at a problem and scroll its line into view
+        function goToProblem(d) {
+            const lines = editor.value.split('\n');
+            if (d.line > lines.length) return;
+            let offset = 0;
+            for (let i = 0; i < d.line - 1; i++) {
+                offset += lines[i].length + 1;
+            }
+            offset += columnOffset(lines[d.line - 1], d.column);
+            editor.focus();
+            editor.setSelectionRange(offset, offset);
+            const line = highlights.children[d.line - 1];
+            if (line) {
+                editor.scrollTop = Math.max(0, line.offsetTop - editor.clientHeight / 3);
+            }
+            updateCursorPosition();
+        }
+
+        editor.addEventListener('scroll', () => {
+            highlights.scrollTop = editor.scrollTop;
+        });
+        window.addEventListener('resize', renderHighlights);
+
+        renderHighlights();
+        renderProblems();
+
+        // Make task list checkboxes in the preview toggle the matching
+        // marker in the editor, using the source line emitted by the renderer
+        function setupTaskCheckboxes() {
+            const previewDoc = preview.contentDocument || preview.contentWindow.document;
+            if (!previewDoc) return;
+            previewDoc.querySelectorAll('input.task-list-item-checkbox[data-task-line]').forEach((checkbox) => {
+                checkbox.disabled = false;
+                checkbox.addEventListener('change', () => {
+                    toggleTask(parseInt(checkbox.dataset.taskLine, 10), checkbox.checked);
+                });
+            });
+        }
+
+        function toggleTask(lineNumber, checked) {
+            const lines = editor.value.split('\n');
+            const index = lineNumber - 1;
+            if (index < 0 || index >= lines.length) return;
+
+            const marker = checked ? '[x]' : '[ ]';
+            const updated = lines[index].replace(/^(\s*(?:>\s*)*(?:[-*+]|\d+[.)])\s+)\[[ xX]\]/, '$1' + marker);
+            if (updated === lines[index]) return;
+
+            lines[index] = updated;
+            editor.value = lines.join('\n');
+            checkDirty();
+            updatePreview();
+            statusText.textContent = 'Task on line ' + lineNumber + (checked ? ' checked' : ' unchecked');
+        }
+
+        // Paste or drop images: upload them next to the document and
+        // insert a Markdown image at the cursor
+        editor.addEventListener('paste', (e) => {
+            const files = Array.from(e.clipboardData ? e.clipboardData.files : []).filter(isImage);
+            if (files.length === 0) return;
+            e.preventDefault();
+            uploadImages(files);
+        });
+
+        editor.addEventListener('dragover', (e) => {
+            if (e.dataTransfer && Array.from(e.dataTransfer.types).includes('Files')) {
+                e.preventDefault();
+                e.dataTransfer.dropEffect = 'copy';
+            }
+        });
+
+        editor.addEventListener('drop', (e) => {
+            const files = Array.from(e.dataTransfer ? e.dataTransfer.files : []).filter(isImage);
+            if (files.length === 0) return;
+            e.preventDefault();
+            uploadImages(files);
+        });
+
+        function isImage(file) {
+            return file.type.startsWith('image/');
+        }
+
+        async function uploadImages(files) {
+            for (const file of files) {
+                statusText.textContent = 'Uploading ' + (file.name || 'image') + '...';
+                const form = new FormData();
+                form.append('file', file);
+                form.append('document', currentFilename);
+                try {
+                    const response = await fetch('/api/upload', {method: 'POST', body: form});
+                    if (!response.ok) {
+                        throw new Error(await response.text());
+                    }
+                    const data = await response.json();
+                    const alt = (file.name || 'image').replace(/\.[^.]*$/, '').replace(/[\[\]]/g, '');
+                    insertAtCursor('![' + alt + '](' + data.path + ')');
+                    statusText.textContent = 'Image saved: ' + data.path;
+                } catch (error) {
+                    statusText.textContent = 'Upload failed';
+                    alert('Error uploading image: ' + error.message);
+                }
+            }
+        }
+
+        function insertAtCursor(text) {
+            const start = editor.selectionStart;
+            const end = editor.selectionEnd;
+            editor.value = editor.value.substring(0, start) + text + editor.value.substring(end);
+            editor.selectionStart = editor.selectionEnd = start + text.length;
+            editor.focus();
+            checkDirty();
+            updatePreview();
+            updateCursorPosition();
+        }
 
         // Handle tab key
         editor.addEventListener('keydown', (e) => {
@@ -534,6 +1102,7 @@ func generateUIHTML(initialContent string) string {
                 const value = editor.value;
                 editor.value = value.substring(0, start) + '    ' + value.substring(end);
                 editor.selectionStart = editor.selectionEnd = start + 4;
+                renderHighlights();
             }
         });
 
@@ -541,7 +1110,8 @@ func generateUIHTML(initialContent string) string {
             if (ws && ws.readyState === WebSocket.OPEN) {
                 ws.send(JSON.stringify({
                     type: 'convert',
-                    content: editor.value
+                    content: editor.value,
+                    name: currentFilename
                 }));
             }
         }
@@ -590,6 +1160,7 @@ func generateUIHTML(initialContent string) string {
             isDirty = false;
             updateTitle();
             updatePreview();
+            updateBacklinks();
             statusText.textContent = 'New file created';
         }
 
@@ -624,6 +1195,12 @@ func generateUIHTML(initialContent string) string {
             const filename = document.getElementById('open-filename').value;
             if (!filename) return;
 
+            if (await loadFile(filename)) {
+                closeDialogs();
+            }
+        }
+
+        async function loadFile(filename) {
             try {
                 const response = await fetch('/api/load', {
                     method: 'POST',
@@ -639,14 +1216,15 @@ func generateUIHTML(initialContent string) string {
                     isDirty = false;
                     updateTitle();
                     updatePreview();
-                    closeDialogs();
+                    updateBacklinks();
                     statusText.textContent = 'Opened: ' + filename;
-                } else {
-                    alert('Error opening file');
+                    return true;
                 }
+                alert('Error opening file');
             } catch (error) {
                 alert('Error opening file: ' + error.message);
             }
+            return false;
         }
 
         async function saveFile() {
@@ -678,6 +1256,8 @@ func generateUIHTML(initialContent string) string {
                     lastSavedContent = editor.value;
                     isDirty = false;
                     updateTitle();
+                    updatePreview();
+                    updateBacklinks();
                     statusText.textContent = 'Saved: ' + filename;
                 } else {
                     alert('Error saving file');
@@ -687,6 +1267,44 @@ func generateUIHTML(initialContent string) string {
             }
         }
 
+        // Rewrite the document in the style of mdreader fmt. The text is
+        // replaced as if typed, so undo brings the old version back.
+        async function formatDocument() {
+            try {
+                const response = await fetch('/api/format', {
+                    method: 'POST',
+                    headers: {'Content-Type': 'application/json'},
+                    body: JSON.stringify({
+                        document: currentFilename,
+                        content: editor.value
+                    })
+                });
+                const data = await response.json();
+                if (data.status !== 'success') {
+                    alert('Error formatting document');
+                    return;
+                }
+                if (data.content === editor.value) {
+                    statusText.textContent = 'Document is already formatted';
+                    return;
+                }
+                const cursor = editor.selectionStart;
+                const scrollTop = editor.scrollTop;
+                editor.focus();
+                editor.select();
+                if (!document.execCommand('insertText', false, data.content)) {
+                    editor.value = data.content;
+                    editor.dispatchEvent(new Event('input'));
+                }
+                editor.selectionStart = editor.selectionEnd = Math.min(cursor, editor.value.length);
+                editor.scrollTop = scrollTop;
+                updateCursorPosition();
+                statusText.textContent = 'Document formatted';
+            } catch (error) {
+                alert('Error formatting document: ' + error.message);
+            }
+        }
+
         async function exportHTML() {
             const htmlFilename = currentFilename.replace(/\.md$/, '.html');
             const suggestedName = prompt('Export HTML as:', htmlFilename);
@@ -698,7 +1316,7 @@ func generateUIHTML(initialContent string) string {
                     headers: {'Content-Type': 'application/json'},
                     body: JSON.stringify({
                         filename: suggestedName,
-                        content: preview.srcdoc
+                        content: previewHTML
                     })
                 });
                 
@@ -743,6 +1361,11 @@ func generateUIHTML(initialContent string) string {
 
         // Keyboard shortcuts
         document.addEventListener('keydown', (e) => {
+            if (e.shiftKey && e.altKey && e.code === 'KeyF') {
+                e.preventDefault();
+                formatDocument();
+                return;
+            }
             if (e.ctrlKey || e.metaKey) {
                 switch(e.key) {
                     case 's':
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// The sandboxed preview cannot run the code tools script of the page, so
+// the editor gets the settings with the preview and adds the tools itself.
+func TestUIPreviewCodeTools(t *testing.T) {
+	saved := options
+	defer func() { options = saved }()
+	options.Safe = true
+
+	work := &workspace{root: t.TempDir()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(w, r, work)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	content := "---\ncopy_buttons: true\ncollapse_code: 3\n---\n\n```go\nfmt.Println()\n```\n"
+	if err := conn.WriteJSON(Message{Type: "convert", Content: content}); err != nil {
+		t.Fatal(err)
+	}
+	var msg Message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "preview" || !msg.CopyButtons || msg.CollapseLines != 3 {
+		t.Errorf("preview message has type %q, copy buttons %t and collapse lines %d, want preview, true and 3",
+			msg.Type, msg.CopyButtons, msg.CollapseLines)
+	}
+
+	page := generateUIHTML("", "")
+	if !strings.Contains(page, `sandbox="`) || strings.Contains(page, "allow-scripts") {
+		t.Errorf("preview frame is not sandboxed without scripts")
+	}
+	for _, want := range []string{codeToolsJS, "preview.addEventListener('load', setupCodeTools)", "codeTools(preview.contentWindow,"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("editor page does not contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// wikiScheme marks links written as [[Target]] until they are resolved.
+const wikiScheme = "wiki:"
+
+var wikiLinkPattern = regexp.MustCompile(`\[\[([^\[\]|\n]+?)(?:\|([^\[\]\n]+?))?\]\]`)
+
+// expandWikiLinks rewrites [[Target]] and [[Target|Label]] outside code as
+// ordinary Markdown links to "wiki:Target", which resolveWikiLinks points
+// at the right file after parsing. "![[...]]" is left alone.
+func expandWikiLinks(markdown []byte) []byte {
+	if !bytes.Contains(markdown, []byte("[[")) {
+		return markdown
+	}
+
+	var out bytes.Buffer
+	code := newCodeScanner()
+	scanner := bufio.NewScanner(bytes.NewReader(markdown))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(markdown)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case code.inside(line):
+		default:
+			line = expandInlineWikiLinks(line)
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// expandInlineWikiLinks rewrites the wiki links of one line, skipping code
+// spans.
+func expandInlineWikiLinks(line string) string {
+	if !strings.Contains(line, "[[") {
+		return line
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(line); {
+		if line[i] == '`' {
+			n := 0
+			for i+n < len(line) && line[i+n] == '`' {
+				n++
+			}
+			ticks := line[i : i+n]
+			if end := strings.Index(line[i+n:], ticks); end >= 0 {
+				out.WriteString(line[i : i+n+end+n])
+				i += n + end + n
+			} else {
+				out.WriteString(ticks)
+				i += n
+			}
+			continue
+		}
+		if strings.HasPrefix(line[i:], "[[") && (i == 0 || line[i-1] != '!') {
+			if m := wikiLinkPattern.FindStringSubmatchIndex(line[i:]); m != nil && m[0] == 0 {
+				target := strings.TrimSpace(line[i+m[2] : i+m[3]])
+				label := target
+				if m[4] >= 0 {
+					label = strings.TrimSpace(line[i+m[4] : i+m[5]])
+				}
+				fmt.Fprintf(&out, "[%s](%s%s)", label, wikiScheme, url.PathEscape(target))
+				i += m[1]
+				continue
+			}
+		}
+		out.WriteByte(line[i])
+		i++
+	}
+	return out.String()
+}
+
+// wikiTarget returns the target of a link created by expandWikiLinks.
+func wikiTarget(dest string) (string, bool) {
+	if !strings.HasPrefix(dest, wikiScheme) {
+		return "", false
+	}
+	target, err := url.PathUnescape(strings.TrimPrefix(dest, wikiScheme))
+	if err != nil {
+		return "", false
+	}
+	return target, true
+}
+
+// resolveWikiTarget finds the file a wiki link points to and returns the
+// link's URL relative to baseDir, with any "#Heading" as an anchor. A
+// target of only "#Heading" links within the page.
+func resolveWikiTarget(target, baseDir string) (file, dest string, ok bool) {
+	name, heading, _ := strings.Cut(target, "#")
+	anchor := ""
+	if heading != "" {
+		anchor = "#" + blackfriday.SanitizedAnchorName(heading)
+	}
+	if strings.TrimSpace(name) == "" {
+		return "", anchor, anchor != ""
+	}
+
+	file, ok = wikiIndexFor(wikiRoot(baseDir)).lookup(name)
+	if !ok {
+		return "", "", false
+	}
+	base, err := filepath.Abs(baseDir)
+	if err != nil {
+		return "", "", false
+	}
+	rel, err := filepath.Rel(base, file)
+	if err != nil {
+		return "", "", false
+	}
+	return file, (&url.URL{Path: filepath.ToSlash(rel)}).String() + anchor, true
+}
+
+// wikiRoot is the directory wiki links are resolved in: the workspace in
+// the UI, the source directory of a site, or the input file's directory.
+func wikiRoot(baseDir string) string {
+	if options.WikiRoot != "" {
+		return options.WikiRoot
+	}
+	return baseDir
+}
+
+// resolveWikiLinks points the wiki links of doc at their files, relative to
+// options.BaseDir. Links whose target does not exist are kept for
+// RenderNode to show as broken, and reported.
+func (r *CustomHTMLRenderer) resolveWikiLinks(doc *blackfriday.Node) {
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering || node.Type != blackfriday.Link {
+			return blackfriday.GoToNext
+		}
+		target, ok := wikiTarget(string(node.LinkData.Destination))
+		if !ok {
+			return blackfriday.GoToNext
+		}
+		if _, dest, ok := resolveWikiTarget(target, options.BaseDir); ok {
+			node.LinkData.Destination = []byte(dest)
+			return blackfriday.GoToNext
+		}
+		r.brokenWikiLinks[node] = target
+		where := ""
+		if options.Source != "" {
+			where = options.Source + ": "
+		}
+		warnOnce(fmt.Sprintf("%sbroken wiki link [[%s]]", where, target))
+		return blackfriday.GoToNext
+	})
+}
+
+// renderBrokenWikiLink renders a wiki link without a target as a span.
+func renderBrokenWikiLink(target string, entering bool) string {
+	if !entering {
+		return "</span>"
+	}
+	return fmt.Sprintf(`<span class="wikilink-broken" title="No page named &quot;%s&quot;">`, template.HTMLEscapeString(target))
+}
+
+// wikiIndex maps page names and titles below a root directory to files.
+type wikiIndex struct {
+	built time.Time
+	names map[string]string
+}
+
+var (
+	wikiIndexesMu sync.Mutex
+	wikiIndexes   = map[string]*wikiIndex{}
+
+	// wikiIndexMaxAge makes indexes expire, so the UI sees new and renamed
+	// files. Zero keeps them for the life of the process.
+	wikiIndexMaxAge time.Duration
+)
+
+// wikiIndexFor returns the index of root, building it on first use.
+func wikiIndexFor(root string) *wikiIndex {
+	root, _ = filepath.Abs(root)
+	wikiIndexesMu.Lock()
+	defer wikiIndexesMu.Unlock()
+	if index, ok := wikiIndexes[root]; ok && (wikiIndexMaxAge == 0 || time.Since(index.built) < wikiIndexMaxAge) {
+		return index
+	}
+	index := buildWikiIndex(root)
+	wikiIndexes[root] = index
+	return index
+}
+
+// buildWikiIndex registers every Markdown file under its path without
+// extension, its file name and its title. On a clash the file that comes
+// first by path wins, and paths beat file names, which beat titles.
+func buildWikiIndex(root string) *wikiIndex {
+	files := markdownFiles(root)
+	index := &wikiIndex{built: time.Now(), names: map[string]string{}}
+	add := func(name, file string) {
+		if key := wikiKey(name); key != "" {
+			if _, taken := index.names[key]; !taken {
+				index.names[key] = file
+			}
+		}
+	}
+	for _, file := range files {
+		rel, _ := filepath.Rel(root, file)
+		add(strings.TrimSuffix(filepath.ToSlash(rel), filepath.Ext(rel)), file)
+	}
+	for _, file := range files {
+		add(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)), file)
+	}
+	for _, file := range files {
+		if title := documentTitle(file); title != "" {
+			add(title, file)
+		}
+	}
+	return index
+}
+
+// markdownFiles returns the Markdown files below root in path order,
+// skipping hidden files and directories.
+func markdownFiles(root string) []string {
+	var files []string
+	filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if file != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && isMarkdownFile(file) {
+			files = append(files, file)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+func (index *wikiIndex) lookup(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if isMarkdownFile(name) {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	file, ok := index.names[wikiKey(name)]
+	return file, ok
+}
+
+// wikiKey normalizes a page name: case, spaces, hyphens and underscores
+// do not matter, so [[Getting Started]] finds getting-started.md.
+func wikiKey(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == ' ' || r == '-' || r == '_' || r == '\t'
+	}), " ")
+}
+
+// documentTitle returns the front matter title of a Markdown file, or its
+// first heading.
+func documentTitle(file string) string {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	meta, markdown := splitFrontMatter(source)
+	if meta.Title != "" {
+		return meta.Title
+	}
+	return firstHeading(markdown)
+}
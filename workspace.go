@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// maxUploadSize bounds a single pasted or dropped image.
+const maxUploadSize = 20 << 20
+
+// uploadTypes maps the image types accepted by /api/upload to the file
+// extension they are saved with. SVG is left out as it can carry scripts.
+var uploadTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+// workspace is the directory the UI was started in. The UI server only
+// reads and writes files below it.
+type workspace struct {
+	root   string
+	lint   lintSettings // rules for the editor's diagnostics
+	format formatStyle  // style of the editor's "Format document"
+}
+
+func newWorkspace() (*workspace, error) {
+	root, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	return &workspace{root: root}, nil
+}
+
+// resolve turns a slash-separated path relative to the workspace into a
+// file path, refusing anything that would end up outside the workspace,
+// including through symlinks.
+func (ws *workspace) resolve(rel string) (string, error) {
+	p := filepath.Join(ws.root, filepath.FromSlash(path.Clean("/"+rel)))
+	if !ws.contains(realPath(p)) {
+		return "", fmt.Errorf("%s is outside the workspace", rel)
+	}
+	return p, nil
+}
+
+// relative returns name as a slash-separated path relative to the
+// workspace, or false if it lies outside.
+func (ws *workspace) relative(name string) (string, bool) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", false
+	}
+	abs = realPath(abs)
+	if !ws.contains(abs) {
+		return "", false
+	}
+	rel, _ := filepath.Rel(ws.root, abs)
+	return filepath.ToSlash(rel), true
+}
+
+func (ws *workspace) contains(p string) bool {
+	rel, err := filepath.Rel(ws.root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// realPath resolves symlinks in the longest part of p that exists.
+func realPath(p string) string {
+	for dir, rest := p, ""; ; {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return p
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
+
+// serveFiles serves workspace files under /files/ so the preview can load
+// images relative to the document. Directories are not listed. Files are
+// served sandboxed, so an HTML or SVG file opened from a preview link runs
+// no script with the editor's origin.
+func (ws *workspace) serveFiles(w http.ResponseWriter, r *http.Request) {
+	file, err := ws.resolve(strings.TrimPrefix(r.URL.Path, "/files/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(w, r, file)
+}
+
+// handleUpload saves a pasted or dropped image into the assets folder next
+// to the document and returns its path relative to the document. Files are
+// named after their content, so uploading the same image twice reuses it.
+func (ws *workspace) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ext, ok := uploadTypes[http.DetectContentType(data)]
+	if !ok {
+		http.Error(w, "Only PNG, JPEG, GIF, WebP and BMP images can be uploaded", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	docDir, ok := ws.documentDir(r.FormValue("document"))
+	if !ok {
+		http.Error(w, "The document is outside the workspace", http.StatusForbidden)
+		return
+	}
+	assetsRel := filepath.ToSlash(options.AssetsDir)
+	dir, err := ws.resolve(path.Join(docDir, assetsRel))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	name := "image-" + hex.EncodeToString(sum[:])[:10] + ext
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "success",
+		"path":   (&url.URL{Path: path.Join(assetsRel, name)}).String(),
+	})
+}
+
+// documentDir returns the workspace-relative directory of a document name
+// as used by the editor. Documents that were never saved live in the
+// workspace root.
+func (ws *workspace) documentDir(document string) (string, bool) {
+	if document == "" {
+		return ".", true
+	}
+	rel, ok := ws.relative(document)
+	if !ok {
+		return "", false
+	}
+	return path.Dir(rel), true
+}
+
+// previewBase returns the <base> URL for a document so relative URLs in
+// the preview resolve to workspace files.
+func (ws *workspace) previewBase(document string) string {
+	dir, ok := ws.documentDir(document)
+	if !ok {
+		dir = "."
+	}
+	return (&url.URL{Path: path.Clean("/files/"+dir) + "/"}).String()
+}
+
+// previewMu serializes previews, which point the shared options at the
+// document being converted.
+var previewMu sync.Mutex
+
+// convertPreview renders a document for the preview, resolving relative
+// links and wiki links from the document's directory. Diagram tools run
+// without holding previewMu, so a slow one does not hold up other
+// previews; the document is then rendered again from the diagram cache.
+func (ws *workspace) convertPreview(document, content string) string {
+	html, pending := ws.renderPreview(document, content)
+	if len(pending) == 0 {
+		return html
+	}
+	for _, job := range pending {
+		job.run()
+	}
+	html, _ = ws.renderPreview(document, content)
+	return html
+}
+
+// renderPreview renders a document for the preview and returns the
+// diagrams it left out because they are not cached yet.
+func (ws *workspace) renderPreview(document, content string) (string, []diagramJob) {
+	previewMu.Lock()
+	defer previewMu.Unlock()
+	var pending []diagramJob
+	deferredDiagrams = &pending
+	defer func() { deferredDiagrams = nil }()
+
+	dir, ok := ws.documentDir(document)
+	if !ok {
+		dir = "."
+	}
+	options.BaseDir = filepath.Join(ws.root, filepath.FromSlash(dir))
+	options.Source = document
+	return convertMarkdownToHTMLForUI([]byte(content)), pending
+}
+
+// diagnose lints a document being edited and checks its local links, for
+// the editor's problems panel. Its own #anchors are taken from the content
+// being edited, not the saved file.
+func (ws *workspace) diagnose(document, content string) []diagnostic {
+	previewMu.Lock()
+	defer previewMu.Unlock()
+	file := filepath.Join(ws.root, "Untitled.md")
+	if rel, ok := ws.relative(document); ok && document != "" {
+		file = filepath.Join(ws.root, filepath.FromSlash(rel))
+	}
+
+	var diagnostics []diagnostic
+	for _, p := range lintMarkdown(document, []byte(content), ws.lint) {
+		diagnostics = append(diagnostics, p.diagnostic)
+	}
+	c := newLinkChecker()
+	c.anchors[file] = documentAnchors(file, []byte(content))
+	c.checkSource(file, ws.root, []byte(content))
+	for _, d := range c.diagnostics {
+		d.File = document
+		diagnostics = append(diagnostics, d)
+	}
+	sortDiagnostics(diagnostics)
+	return diagnostics
+}
+
+// handleFormat rewrites the document being edited in the style of
+// mdreader fmt, for the editor's "Format document" action.
+func (ws *workspace) handleFormat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		Document string `json:"document"`
+		Content  string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	previewMu.Lock()
+	dir, ok := ws.documentDir(data.Document)
+	if !ok {
+		dir = "."
+	}
+	options.BaseDir = filepath.Join(ws.root, filepath.FromSlash(dir))
+	options.Source = data.Document
+	formatted := formatMarkdown([]byte(data.Content), ws.format)
+	previewMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"content": string(formatted),
+	})
+}
+
+// handleBacklinks lists the workspace documents that link to a document,
+// for the editor's "Linked references" panel. Paths are relative to the
+// workspace, as the editor opens them.
+func (ws *workspace) handleBacklinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		Document string `json:"document"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rel, ok := ws.relative(data.Document)
+	if !ok {
+		http.Error(w, "The document is outside the workspace", http.StatusForbidden)
+		return
+	}
+
+	var docs []linkedDocument
+	for _, file := range markdownFiles(ws.root) {
+		if doc, err := scanDocument(file); err == nil {
+			docs = append(docs, doc)
+		}
+	}
+
+	type entry struct {
+		File    string `json:"file"`
+		Title   string `json:"title"`
+		Context string `json:"context,omitempty"`
+	}
+	entries := []entry{}
+	for _, ref := range findBacklinks(docs)[filepath.Join(ws.root, filepath.FromSlash(rel))] {
+		file, _ := filepath.Rel(ws.root, ref.File)
+		entries = append(entries, entry{File: filepath.ToSlash(file), Title: ref.Title, Context: ref.Context})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "success",
+		"backlinks": entries,
+	})
+}